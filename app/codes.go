@@ -0,0 +1,48 @@
+package app
+
+import "errors"
+
+// Response codes returned to CometBFT. Zero means OK; anything else is an error.
+const (
+	CodeTypeOK          uint32 = 0
+	CodeTypeInvalidTx   uint32 = 1
+	CodeTypeKeyNotFound uint32 = 2
+	CodeTypeUnknownPath uint32 = 3
+
+	CodeTypeEmptyTx          uint32 = 4
+	CodeTypeMissingSeparator uint32 = 5
+	CodeTypeOversizedTx      uint32 = 6
+
+	CodeTypeInvalidGasLimit  uint32 = 7
+	CodeTypeOutOfGas         uint32 = 8
+	CodeTypeBlockGasExceeded uint32 = 9
+
+	CodeTypeHeightPruned    uint32 = 10
+	CodeTypeHeightNotExists uint32 = 11
+
+	CodeTypeInvalidValidatorTx uint32 = 12
+)
+
+// TxErrorCode maps a transaction decoding error to its response code.
+func TxErrorCode(err error) uint32 {
+	switch {
+	case err == nil:
+		return CodeTypeOK
+	case errors.Is(err, ErrEmptyTx):
+		return CodeTypeEmptyTx
+	case errors.Is(err, ErrMissingSeparator):
+		return CodeTypeMissingSeparator
+	case errors.Is(err, ErrOversizedTx):
+		return CodeTypeOversizedTx
+	case errors.Is(err, ErrInvalidGasLimit):
+		return CodeTypeInvalidGasLimit
+	case errors.Is(err, ErrOutOfGas):
+		return CodeTypeOutOfGas
+	case errors.Is(err, ErrBlockGasExceeded):
+		return CodeTypeBlockGasExceeded
+	case errors.Is(err, ErrInvalidValidatorTx):
+		return CodeTypeInvalidValidatorTx
+	default:
+		return CodeTypeInvalidTx
+	}
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxBlockGas is the block gas limit written to new genesis files.
+// The limit in effect is the chain's consensus params block.max_gas, so that
+// every validator enforces the same one.
+const DefaultMaxBlockGas = 10_000_000
+
+var ErrBlockGasExceeded = errors.New("block gas limit exceeded")
+
+// GasMeter tracks the gas consumed by the txs of a single block.
+type GasMeter struct {
+	limit    int64
+	consumed int64
+}
+
+// NewGasMeter returns a meter that allows up to limit gas. Zero means unlimited.
+func NewGasMeter(limit int64) *GasMeter {
+	return &GasMeter{limit: limit}
+}
+
+// Consume charges gas to the block, or returns ErrBlockGasExceeded and
+// charges nothing if that would take the block over its limit.
+func (m *GasMeter) Consume(gas int64) error {
+	if m.limit > 0 && m.consumed+gas > m.limit {
+		return fmt.Errorf("%w: used %d, limit %d, tx needs %d", ErrBlockGasExceeded, m.consumed, m.limit, gas)
+	}
+	m.consumed += gas
+	return nil
+}
+
+// Consumed returns the gas charged so far.
+func (m *GasMeter) Consumed() int64 {
+	return m.consumed
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+func TestGasMeter(t *testing.T) {
+	meter := NewGasMeter(100)
+	if err := meter.Consume(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := meter.Consume(50); !errors.Is(err, ErrBlockGasExceeded) {
+		t.Fatalf("Consume over the limit = %v, want ErrBlockGasExceeded", err)
+	}
+	// A rejected charge consumes nothing, so a smaller one still fits.
+	if err := meter.Consume(40); err != nil {
+		t.Fatal(err)
+	}
+	if got := meter.Consumed(); got != 100 {
+		t.Errorf("Consumed = %d, want 100", got)
+	}
+
+	if err := NewGasMeter(0).Consume(1 << 40); err != nil {
+		t.Errorf("unlimited meter: %v", err)
+	}
+}
+
+func TestDeliverTXBlockGas(t *testing.T) {
+	state := NewState(dbm.NewMemDB(), 0)
+	// Room for "a=1" and "b=2" (20 gas each), but not also "big=value" (80 gas).
+	blockGas := NewGasMeter(50)
+
+	wantCodes := map[string]uint32{
+		"a=1":       CodeTypeOK,
+		"big=value": CodeTypeBlockGasExceeded,
+		"b=2":       CodeTypeOK,
+	}
+	for _, tx := range []string{"a=1", "big=value", "b=2"} {
+		res, _ := state.DeliverTX(KVTxDecoder{}, []byte(tx), blockGas)
+		if res.Code != wantCodes[tx] {
+			t.Errorf("DeliverTX(%q): code %d, want %d (%s)", tx, res.Code, wantCodes[tx], res.Log)
+		}
+	}
+	if err := state.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{"a": "1", "b": "2", "big": ""} {
+		if value, err := state.GetCommitted([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("GetCommitted(%s) = %q, %v; want %q", key, value, err, want)
+		}
+	}
+}
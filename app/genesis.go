@@ -0,0 +1,44 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GenesisState is the app_state section of genesis.json: a flat set of
+// key/value entries written to the state store before the first block.
+//
+//	"app_state": {"name": "satoshi", "greeting": "gm"}
+type GenesisState map[string]string
+
+// InitGenesis decodes appStateBytes and stages every entry in the state.
+// Empty app state is a no-op.
+func (s *State) InitGenesis(appStateBytes []byte) error {
+	if len(appStateBytes) == 0 {
+		return nil
+	}
+	var genesis GenesisState
+	if err := json.Unmarshal(appStateBytes, &genesis); err != nil {
+		return fmt.Errorf("decoding genesis app_state: %w", err)
+	}
+
+	keys := make([]string, 0, len(genesis))
+	for key := range genesis {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if strings.HasPrefix(key, string(validatorPrefix)) {
+			return fmt.Errorf("genesis entry %q: keys starting with %q are reserved for validators", key, validatorPrefix)
+		}
+		if strings.HasPrefix(key, string(paramsPrefix)) {
+			return fmt.Errorf("genesis entry %q: keys starting with %q are reserved for consensus params", key, paramsPrefix)
+		}
+		if err := s.Set([]byte(key), []byte(genesis[key])); err != nil {
+			return fmt.Errorf("genesis entry %q: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+func TestInitGenesis(t *testing.T) {
+	state := NewState(dbm.NewMemDB(), 0)
+	if err := state.InitGenesis([]byte(`{"name": "satoshi", "greeting": "gm"}`)); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"name": "satoshi", "greeting": "gm"} {
+		if value, err := state.Get([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("Get(%s) = %q, %v; want %q", key, value, err, want)
+		}
+	}
+}
+
+func TestInitGenesisEmpty(t *testing.T) {
+	for _, appState := range [][]byte{nil, {}} {
+		state := NewState(dbm.NewMemDB(), 0)
+		if err := state.InitGenesis(appState); err != nil {
+			t.Fatalf("InitGenesis(%q): %v", appState, err)
+		}
+		if n := len(state.pending); n != 0 {
+			t.Errorf("InitGenesis(%q) staged %d writes, want none", appState, n)
+		}
+	}
+}
+
+func TestInitGenesisInvalid(t *testing.T) {
+	for _, appState := range []string{`{"name": `, `["name"]`, `{"val:abc": "10"}`, `{"params:block_max_gas": "0"}`} {
+		state := NewState(dbm.NewMemDB(), 0)
+		if err := state.InitGenesis([]byte(appState)); err == nil {
+			t.Errorf("InitGenesis(%s) succeeded, want error", appState)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"strconv"
+)
+
+// paramsPrefix is the state key prefix under which the consensus params the
+// app enforces itself are kept, as decimal strings:
+//
+//	params:block_max_bytes = <bytes>
+//	params:block_max_gas = <gas>
+//
+// Keeping them in the state puts them under the AppHash and in snapshots, so
+// a node restored by state sync, which never sees InitChain, enforces the
+// same limits as the rest of the network. Key/value txs can't write these
+// keys, see Tx.validate.
+var paramsPrefix = []byte("params:")
+
+var (
+	blockMaxBytesKey = []byte("params:block_max_bytes")
+	blockMaxGasKey   = []byte("params:block_max_gas")
+)
+
+// BlockParams are the block limits of the chain's consensus params, as set
+// in genesis: -1 means no limit and zero means the param was never set.
+type BlockParams struct {
+	MaxBytes int64
+	MaxGas   int64
+}
+
+// SetBlockParams stages the chain's block limits.
+func (s *State) SetBlockParams(params BlockParams) error {
+	if err := s.Set(blockMaxBytesKey, []byte(strconv.FormatInt(params.MaxBytes, 10))); err != nil {
+		return err
+	}
+	return s.Set(blockMaxGasKey, []byte(strconv.FormatInt(params.MaxGas, 10)))
+}
+
+// BlockParams returns the chain's block limits, including staged changes.
+func (s *State) BlockParams() (BlockParams, error) {
+	maxBytes, err := s.getParam(blockMaxBytesKey)
+	if err != nil {
+		return BlockParams{}, err
+	}
+	maxGas, err := s.getParam(blockMaxGasKey)
+	if err != nil {
+		return BlockParams{}, err
+	}
+	return BlockParams{MaxBytes: maxBytes, MaxGas: maxGas}, nil
+}
+
+// getParam returns the value of a param key, or zero if it was never set.
+func (s *State) getParam(key []byte) (int64, error) {
+	bz, err := s.Get(key)
+	if err != nil || len(bz) == 0 {
+		return 0, err
+	}
+	return strconv.ParseInt(string(bz), 10, 64)
+}
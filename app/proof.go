@@ -0,0 +1,114 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	cmtcrypto "github.com/cometbft/cometbft/api/cometbft/crypto/v1"
+	"github.com/cometbft/cometbft/crypto/merkle"
+)
+
+// Each key/value pair is a leaf of the state's Merkle tree, encoded as
+//
+//	uvarint(len(key)) | key | uvarint(32) | sha256(value)
+//
+// which is the leaf merkle.ValueOp rebuilds, so proofs from ProveAt verify
+// with the default CometBFT proof runtime, keyed by the URL-encoded key.
+func leafBytes(key, value []byte) []byte {
+	valueHash := sha256.Sum256(value)
+	bz := make([]byte, 0, 2*binary.MaxVarintLen64+len(key)+len(valueHash))
+	bz = binary.AppendUvarint(bz, uint64(len(key)))
+	bz = append(bz, key...)
+	bz = binary.AppendUvarint(bz, uint64(len(valueHash)))
+	return append(bz, valueHash[:]...)
+}
+
+// proofTree is the Merkle tree of the state at one height: its keys in
+// ascending order, and the proof of each key's leaf.
+type proofTree struct {
+	height int64
+	keys   [][]byte
+	proofs []*merkle.Proof
+}
+
+// ProveAt returns a proof of key's value as of height, against the AppHash
+// for that height. It returns nil if the key isn't set at that height.
+// Building the tree is linear in the state size, so the last tree built is
+// cached and proofs for the same height are served from it.
+func (s *State) ProveAt(key []byte, height int64) (*cmtcrypto.ProofOps, error) {
+	earliest, err := s.EarliestHeight()
+	if err != nil {
+		return nil, err
+	}
+	if height < earliest {
+		return nil, fmt.Errorf("%w: %d is below the earliest retained height %d", ErrHeightPruned, height, earliest)
+	}
+
+	s.proofMu.Lock()
+	defer s.proofMu.Unlock()
+	if s.proofs == nil || s.proofs.height != height {
+		tree, err := s.buildProofTree(height)
+		if err != nil {
+			return nil, err
+		}
+		s.proofs = tree
+	}
+
+	keys := s.proofs.keys
+	i := sort.Search(len(keys), func(i int) bool { return bytes.Compare(keys[i], key) >= 0 })
+	if i == len(keys) || !bytes.Equal(keys[i], key) {
+		return nil, nil
+	}
+	op := merkle.NewValueOp(key, s.proofs.proofs[i]).ProofOp()
+	return &cmtcrypto.ProofOps{Ops: []cmtcrypto.ProofOp{op}}, nil
+}
+
+// buildProofTree builds the tree of the state at height. The latest
+// committed height is read from the current state; older heights, or any
+// height before the first Commit since the State was opened, are rebuilt
+// from the versions.
+func (s *State) buildProofTree(height int64) (*proofTree, error) {
+	tree := &proofTree{height: height}
+	var leaves [][]byte
+	if height == s.height {
+		it, err := s.db.Iterator(stateKeyPrefix, prefixEnd(stateKeyPrefix))
+		if err != nil {
+			return nil, err
+		}
+		defer it.Close()
+		for ; it.Valid(); it.Next() {
+			key := append([]byte{}, it.Key()[len(stateKeyPrefix):]...)
+			tree.keys = append(tree.keys, key)
+			leaves = append(leaves, leafBytes(key, it.Value()))
+		}
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+	} else {
+		view, err := s.ViewAt(height)
+		if err != nil {
+			return nil, err
+		}
+		defer view.Close()
+		type pair struct{ key, value []byte }
+		var pairs []pair
+		err = view.Iterate(func(key, value []byte) error {
+			pairs = append(pairs, pair{key, value})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		// The view is ordered by key length first; leaves are by key.
+		sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].key, pairs[j].key) < 0 })
+		for _, p := range pairs {
+			tree.keys = append(tree.keys, p.key)
+			leaves = append(leaves, leafBytes(p.key, p.value))
+		}
+	}
+	_, tree.proofs = merkle.ProofsFromByteSlices(leaves)
+	return tree, nil
+}
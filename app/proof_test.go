@@ -0,0 +1,71 @@
+package app
+
+import (
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/merkle"
+)
+
+func TestProveAt(t *testing.T) {
+	db := dbm.NewMemDB()
+	state := NewState(db, 0)
+	hashes := make(map[int64][]byte)
+	commit := func(height int64, pairs ...string) {
+		for i := 0; i < len(pairs); i += 2 {
+			if err := state.Set([]byte(pairs[i]), []byte(pairs[i+1])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hash, err := state.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := state.Commit(height); err != nil {
+			t.Fatal(err)
+		}
+		hashes[height] = hash
+	}
+	commit(1, "name", "satoshi", "greeting", "gm", "x", "1")
+	commit(2, "name", "nakamoto", "longer-key", "2")
+
+	verify := func(t *testing.T, state *State, height int64, key, value string) {
+		t.Helper()
+		proof, err := state.ProveAt([]byte(key), height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof == nil {
+			t.Fatalf("no proof of %s at height %d", key, height)
+		}
+		keyPath := merkle.KeyPath{}.AppendKey([]byte(key), merkle.KeyEncodingURL).String()
+		if err := merkle.DefaultProofRuntime().VerifyValue(proof, hashes[height], keyPath, []byte(value)); err != nil {
+			t.Errorf("proof of %s=%s at height %d: %v", key, value, height, err)
+		}
+		if err := merkle.DefaultProofRuntime().VerifyValue(proof, hashes[height], keyPath, []byte("forged")); err == nil {
+			t.Errorf("proof of %s at height %d verifies a forged value", key, height)
+		}
+	}
+
+	// Height 2 is built from the current state, height 1 from the versions,
+	// and the cache must follow the height being proven.
+	for _, state := range []*State{state, NewState(db, 0)} {
+		verify(t, state, 2, "name", "nakamoto")
+		verify(t, state, 2, "x", "1")
+		verify(t, state, 1, "name", "satoshi")
+		verify(t, state, 1, "greeting", "gm")
+		verify(t, state, 2, "longer-key", "2")
+	}
+
+	if proof, err := state.ProveAt([]byte("longer-key"), 1); err != nil || proof != nil {
+		t.Errorf("ProveAt(longer-key, 1) = %v, %v; want nil", proof, err)
+	}
+	res, err := state.QueryData(&abci.QueryRequest{Path: QueryPathStore, Data: []byte("missing"), Prove: true}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != CodeTypeKeyNotFound || res.ProofOps != nil {
+		t.Errorf("query of a missing key: code %d, proof %v; want %d and no proof", res.Code, res.ProofOps, CodeTypeKeyNotFound)
+	}
+}
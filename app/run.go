@@ -1,28 +1,156 @@
 package app
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+
 	abci "github.com/cometbft/cometbft/abci/types"
 )
 
 // This file contains the main hooks into the CometBFT application.
 
-func ProcessTX(req *abci.CheckTxRequest) (*abci.CheckTxResponse, error) {
-	// This function is a placeholder for the CheckTx logic.
-	// This will contain the main logic for your application and entrypoint for transaction validation.
-	// It will be implemented in the future.
-	return &abci.CheckTxResponse{}, nil
+// QueryPathStore is the abci_query path for key/value lookups.
+const QueryPathStore = "/store"
+
+// Event types emitted from FinalizeBlock.
+const (
+	EventTypeKVStore   = "kvstore"
+	EventTypeBlock     = "kvstore_block"
+	EventTypeValidator = "validator"
+)
+
+// ProcessTX validates a transaction before it is admitted to the mempool.
+// Invalid transactions are rejected with a non-zero Code, not an error;
+// an error is reserved for failures that should halt the connection.
+func ProcessTX(decoder TxDecoder, req *abci.CheckTxRequest) (*abci.CheckTxResponse, error) {
+	tx, err := decoder.Decode(req.Tx)
+	if err == nil {
+		err = tx.CheckGas()
+	}
+	if err != nil {
+		return &abci.CheckTxResponse{Code: TxErrorCode(err), Log: err.Error()}, nil
+	}
+	return &abci.CheckTxResponse{Code: CodeTypeOK, GasWanted: tx.GasWanted()}, nil
+}
+
+// DeliverTX applies a transaction to the staged state during FinalizeBlock,
+// charging its gas to the block's meter. A tx that runs out of gas, or that
+// does not fit in the block's remaining gas, is not applied.
+// For an applied validator tx it also returns the resulting validator update.
+func (s *State) DeliverTX(decoder TxDecoder, raw []byte, blockGas *GasMeter) (*abci.ExecTxResult, *abci.ValidatorUpdate) {
+	tx, err := decoder.Decode(raw)
+	if err != nil {
+		return &abci.ExecTxResult{Code: TxErrorCode(err), Log: err.Error()}, nil
+	}
+	if err := tx.CheckGas(); err != nil {
+		return &abci.ExecTxResult{Code: TxErrorCode(err), Log: err.Error(), GasWanted: tx.GasWanted(), GasUsed: tx.GasLimit}, nil
+	}
+	if err := blockGas.Consume(tx.Gas()); err != nil {
+		return &abci.ExecTxResult{Code: TxErrorCode(err), Log: err.Error(), GasWanted: tx.GasWanted()}, nil
+	}
+
+	if tx.ValidatorUpdate != nil {
+		if err := s.UpdateValidator(*tx.ValidatorUpdate); err != nil {
+			return &abci.ExecTxResult{Code: TxErrorCode(err), Log: err.Error(), GasWanted: tx.GasWanted(), GasUsed: tx.Gas()}, nil
+		}
+	} else if err := s.Set(tx.Key, tx.Value); err != nil {
+		return &abci.ExecTxResult{Code: CodeTypeInvalidTx, Log: err.Error(), GasWanted: tx.GasWanted(), GasUsed: tx.Gas()}, nil
+	}
+	return &abci.ExecTxResult{
+		Code:      CodeTypeOK,
+		GasWanted: tx.GasWanted(),
+		GasUsed:   tx.Gas(),
+		Events:    []abci.Event{txEvent(tx)},
+	}, tx.ValidatorUpdate
 }
 
-func CommitData(req *abci.CommitRequest) (*abci.CommitResponse, error) {
-	// This function is a placeholder for the Commit logic.
-	// It will contain the main logic for your application and entrypoint for committing data to state/db.
-	// It will be implemented in the future.
+// txEvent makes an applied tx indexable, e.g. by subscribing to kvstore.key='name'.
+func txEvent(tx Tx) abci.Event {
+	if val := tx.ValidatorUpdate; val != nil {
+		return abci.Event{
+			Type: EventTypeValidator,
+			Attributes: []abci.EventAttribute{
+				{Key: "pub_key", Value: base64.StdEncoding.EncodeToString(val.PubKeyBytes), Index: true},
+				{Key: "power", Value: strconv.FormatInt(val.Power, 10), Index: true},
+			},
+		}
+	}
+	return abci.Event{
+		Type: EventTypeKVStore,
+		Attributes: []abci.EventAttribute{
+			{Key: "key", Value: string(tx.Key), Index: true},
+			{Key: "value", Value: string(tx.Value), Index: true},
+		},
+	}
+}
+
+// BlockEvent summarizes the txs applied in a block.
+func BlockEvent(results []*abci.ExecTxResult) abci.Event {
+	applied := 0
+	for _, res := range results {
+		if res.IsOK() {
+			applied++
+		}
+	}
+	return abci.Event{
+		Type: EventTypeBlock,
+		Attributes: []abci.EventAttribute{
+			{Key: "applied_txs", Value: strconv.Itoa(applied), Index: true},
+		},
+	}
+}
+
+// CommitData persists the state staged during FinalizeBlock as the state at height.
+func (s *State) CommitData(height int64) (*abci.CommitResponse, error) {
+	if err := s.Commit(height); err != nil {
+		return nil, err
+	}
 	return &abci.CommitResponse{}, nil
 }
 
-func QueryData(req *abci.QueryRequest) (*abci.QueryResponse, error) {
-	// This function is a placeholder for the Query logic.
-	// It will contain the main logic for your application and entrypoint for querying data from state/db.
-	// It will be implemented in the future.
-	return &abci.QueryResponse{}, nil
+// QueryData serves read-only lookups against the committed state as of height.
+// Supported paths:
+//
+//	/store  req.Data is the key to look up
+func (s *State) QueryData(req *abci.QueryRequest, height int64) (*abci.QueryResponse, error) {
+	switch req.Path {
+	case QueryPathStore:
+		return s.queryStore(req, height)
+	default:
+		return &abci.QueryResponse{
+			Code:   CodeTypeUnknownPath,
+			Log:    fmt.Sprintf("unknown query path %q", req.Path),
+			Height: height,
+		}, nil
+	}
+}
+
+func (s *State) queryStore(req *abci.QueryRequest, height int64) (*abci.QueryResponse, error) {
+	if len(req.Data) == 0 {
+		return &abci.QueryResponse{Code: CodeTypeKeyNotFound, Log: "key cannot be empty", Height: height}, nil
+	}
+	value, err := s.GetAt(req.Data, height)
+	if errors.Is(err, ErrHeightPruned) {
+		return &abci.QueryResponse{Code: CodeTypeHeightPruned, Key: req.Data, Log: err.Error(), Height: height}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return &abci.QueryResponse{
+			Code:   CodeTypeKeyNotFound,
+			Key:    req.Data,
+			Log:    fmt.Sprintf("key %q not found", req.Data),
+			Height: height,
+		}, nil
+	}
+	res := &abci.QueryResponse{Code: CodeTypeOK, Key: req.Data, Value: value, Log: "exists", Height: height}
+	if req.Prove {
+		if res.ProofOps, err = s.ProveAt(req.Data, height); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
 }
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+)
+
+func TestQueryData(t *testing.T) {
+	state := NewState(dbm.NewMemDB(), 0)
+	if err := state.Set([]byte("name"), []byte("satoshi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		key       string
+		wantCode  uint32
+		wantValue string
+	}{
+		{"present key", QueryPathStore, "name", CodeTypeOK, "satoshi"},
+		{"absent key", QueryPathStore, "missing", CodeTypeKeyNotFound, ""},
+		{"empty key", QueryPathStore, "", CodeTypeKeyNotFound, ""},
+		{"unknown path", "/nope", "name", CodeTypeUnknownPath, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := state.QueryData(&abci.QueryRequest{Path: tt.path, Data: []byte(tt.key)}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d (%s)", res.Code, tt.wantCode, res.Log)
+			}
+			if string(res.Value) != tt.wantValue {
+				t.Errorf("value = %q, want %q", res.Value, tt.wantValue)
+			}
+			if res.Height != 1 {
+				t.Errorf("height = %d, want 1", res.Height)
+			}
+		})
+	}
+}
+
+func TestProcessTX(t *testing.T) {
+	tests := []struct {
+		name          string
+		tx            []byte
+		wantCode      uint32
+		wantGasWanted int64
+	}{
+		{"valid", []byte("name=satoshi"), CodeTypeOK, 11 * GasPerByte},
+		{"declared gas limit", []byte("gas:500:name=satoshi"), CodeTypeOK, 500},
+		{"empty", nil, CodeTypeEmptyTx, 0},
+		{"missing separator", []byte("name"), CodeTypeMissingSeparator, 0},
+		{"oversized", bytes.Repeat([]byte("a"), MaxTxSize+1), CodeTypeOversizedTx, 0},
+		{"invalid gas limit", []byte("gas:lots:name=satoshi"), CodeTypeInvalidGasLimit, 0},
+		{"out of gas", []byte("gas:10:name=satoshi"), CodeTypeOutOfGas, 0},
+		{"reserved params key", []byte("params:block_max_gas=-1"), CodeTypeInvalidTx, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ProcessTX(KVTxDecoder{}, &abci.CheckTxRequest{Tx: tt.tx})
+			if err != nil {
+				t.Fatalf("ProcessTX returned error %v, want a response code", err)
+			}
+			if res.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d (%s)", res.Code, tt.wantCode, res.Log)
+			}
+			if res.GasWanted != tt.wantGasWanted {
+				t.Errorf("gas wanted = %d, want %d", res.GasWanted, tt.wantGasWanted)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+)
+
+const (
+	// SnapshotFormat is the only snapshot encoding this app produces and accepts:
+	// a stream of uvarint length-prefixed key/value pairs, ordered by key length
+	// and then key, split into fixed-size chunks. Snapshot.Metadata holds the
+	// sha256 of every chunk.
+	SnapshotFormat uint32 = 1
+
+	snapshotChunkSize = 1 << 20
+	snapshotFile      = "snapshot.json"
+)
+
+var ErrChunkHashMismatch = errors.New("snapshot chunk hash mismatch")
+
+// SnapshotStore keeps state-sync snapshots on disk, one directory per height:
+//
+//	<dir>/<height>/snapshot.json
+//	<dir>/<height>/<chunk index>
+type SnapshotStore struct {
+	dir        string
+	keepRecent int
+}
+
+func NewSnapshotStore(dir string, keepRecent int) (*SnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{dir: dir, keepRecent: keepRecent}, nil
+}
+
+// Create writes the state in view out as a snapshot at height and prunes
+// all but the most recent keepRecent snapshots. Only one chunk is held in
+// memory at a time.
+func (s *SnapshotStore) Create(view *StateView, height uint64) (*abci.Snapshot, error) {
+	// Write into a temporary directory first so a crash never leaves a
+	// half-written snapshot behind to be advertised.
+	tmpDir := s.heightDir(height) + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	w := &chunkWriter{dir: tmpDir}
+	err := view.Iterate(func(key, value []byte) error {
+		w.writeBytes(key)
+		w.writeBytes(value)
+		return w.flush(false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Always produce at least one chunk, even for an empty state.
+	if err := w.flush(true); err != nil {
+		return nil, err
+	}
+
+	snapshot := &abci.Snapshot{
+		Height:   height,
+		Format:   SnapshotFormat,
+		Chunks:   w.chunks,
+		Metadata: w.hashes,
+	}
+	h := sha256.Sum256(snapshot.Metadata)
+	snapshot.Hash = h[:]
+
+	bz, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, snapshotFile), bz, 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(s.heightDir(height)); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpDir, s.heightDir(height)); err != nil {
+		return nil, err
+	}
+
+	return snapshot, s.prune()
+}
+
+// List returns the stored snapshots, most recent first.
+func (s *SnapshotStore) List() ([]*abci.Snapshot, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []*abci.Snapshot
+	for _, entry := range entries {
+		height, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		snapshot, err := s.load(height)
+		if errors.Is(err, os.ErrNotExist) {
+			// Removed by a concurrent prune.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Height > snapshots[j].Height
+	})
+	return snapshots, nil
+}
+
+// LoadChunk returns the raw bytes of a single snapshot chunk.
+func (s *SnapshotStore) LoadChunk(height uint64, format uint32, chunk uint32) ([]byte, error) {
+	if format != SnapshotFormat {
+		return nil, fmt.Errorf("unsupported snapshot format %d", format)
+	}
+	return os.ReadFile(filepath.Join(s.heightDir(height), strconv.FormatUint(uint64(chunk), 10)))
+}
+
+func (s *SnapshotStore) load(height uint64) (*abci.Snapshot, error) {
+	bz, err := os.ReadFile(filepath.Join(s.heightDir(height), snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &abci.Snapshot{}
+	if err := json.Unmarshal(bz, snapshot); err != nil {
+		return nil, fmt.Errorf("decoding snapshot at height %d: %w", height, err)
+	}
+	return snapshot, nil
+}
+
+func (s *SnapshotStore) prune() error {
+	if s.keepRecent <= 0 {
+		return nil
+	}
+	snapshots, err := s.List()
+	if err != nil {
+		return err
+	}
+	for i := s.keepRecent; i < len(snapshots); i++ {
+		if err := os.RemoveAll(s.heightDir(snapshots[i].Height)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SnapshotStore) heightDir(height uint64) string {
+	return filepath.Join(s.dir, strconv.FormatUint(height, 10))
+}
+
+// SnapshotRestore reassembles a snapshot offered by a peer, one chunk at a time.
+type SnapshotRestore struct {
+	Snapshot *abci.Snapshot
+	AppHash  []byte
+
+	// rest is the start of an entry cut off at the end of the last chunk.
+	rest []byte
+	next uint32
+}
+
+// NewSnapshotRestore validates the snapshot's format and metadata.
+func NewSnapshotRestore(snapshot *abci.Snapshot, appHash []byte) (*SnapshotRestore, error) {
+	if snapshot.Format != SnapshotFormat {
+		return nil, fmt.Errorf("unsupported snapshot format %d", snapshot.Format)
+	}
+	if snapshot.Chunks == 0 || len(snapshot.Metadata) != int(snapshot.Chunks)*sha256.Size {
+		return nil, errors.New("snapshot metadata does not match its chunk count")
+	}
+	h := sha256.Sum256(snapshot.Metadata)
+	if !bytes.Equal(h[:], snapshot.Hash) {
+		return nil, errors.New("snapshot hash does not match its metadata")
+	}
+	return &SnapshotRestore{Snapshot: snapshot, AppHash: appHash}, nil
+}
+
+// AddChunk verifies a chunk against the snapshot metadata and stages the
+// entries it completes in state, so chunks aren't buffered. The staged writes
+// themselves are held in memory until the restored state is committed.
+// AddChunk reports whether all chunks have now been received.
+func (r *SnapshotRestore) AddChunk(state *State, index uint32, chunk []byte) (bool, error) {
+	if index != r.next {
+		return false, fmt.Errorf("expected chunk %d, got %d", r.next, index)
+	}
+	h := sha256.Sum256(chunk)
+	if !bytes.Equal(h[:], r.Snapshot.Metadata[index*sha256.Size:(index+1)*sha256.Size]) {
+		return false, ErrChunkHashMismatch
+	}
+
+	data := append(r.rest, chunk...)
+	for {
+		key, rest, ok, err := nextBytes(data)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			break
+		}
+		value, rest, ok, err := nextBytes(rest)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			break
+		}
+		if err := state.Set(key, value); err != nil {
+			return false, err
+		}
+		data = rest
+	}
+	r.rest = append([]byte{}, data...)
+	r.next++
+
+	done := r.next == r.Snapshot.Chunks
+	if done && len(r.rest) > 0 {
+		return false, errors.New("decoding snapshot entry: truncated data")
+	}
+	return done, nil
+}
+
+// chunkWriter encodes snapshot entries and writes them out as chunk files
+// of snapshotChunkSize bytes, recording the hash of each.
+type chunkWriter struct {
+	dir    string
+	buf    bytes.Buffer
+	chunks uint32
+	hashes []byte
+}
+
+func (w *chunkWriter) writeBytes(bz []byte) {
+	w.buf.Write(binary.AppendUvarint(nil, uint64(len(bz))))
+	w.buf.Write(bz)
+}
+
+// flush writes out every full chunk buffered, and with final set the rest
+// as the last chunk.
+func (w *chunkWriter) flush(final bool) error {
+	for w.buf.Len() > snapshotChunkSize || (final && (w.buf.Len() > 0 || w.chunks == 0)) {
+		chunk := w.buf.Next(min(w.buf.Len(), snapshotChunkSize))
+		if err := os.WriteFile(filepath.Join(w.dir, strconv.FormatUint(uint64(w.chunks), 10)), chunk, 0o644); err != nil {
+			return err
+		}
+		h := sha256.Sum256(chunk)
+		w.hashes = append(w.hashes, h[:]...)
+		w.chunks++
+	}
+	return nil
+}
+
+// nextBytes splits a uvarint length-prefixed byte slice off the front of
+// data. ok is false if data ends before the slice does.
+func nextBytes(data []byte) (bz, rest []byte, ok bool, err error) {
+	n, k := binary.Uvarint(data)
+	if k < 0 {
+		return nil, nil, false, errors.New("decoding snapshot entry: invalid length")
+	}
+	if k == 0 || n > uint64(len(data)-k) {
+		return nil, data, false, nil
+	}
+	end := k + int(n)
+	return data[k:end], data[end:], true, nil
+}
@@ -0,0 +1,224 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/crypto/merkle"
+)
+
+// stateKeyPrefix namespaces application keys in the database so they can't
+// collide with the metadata keys (lastHeight, lastAppHash) kept alongside them.
+var stateKeyPrefix = []byte("kv:")
+
+// State is the application key/value store.
+// Writes made while executing a block are staged in memory and in a write
+// batch, and only become durable once Commit is called. Every committed write
+// is also kept as a version at its height, see versions.go.
+type State struct {
+	db         dbm.DB
+	batch      dbm.Batch
+	pending    map[string][]byte
+	keepRecent int64
+	// startPowers holds, for each validator updated in the block being
+	// executed, its power at the start of the block. See UpdateValidator.
+	startPowers map[string]int64
+	// numKeys is the number of committed keys, or -1 until first counted.
+	numKeys int
+	// height is the last height committed since the State was opened.
+	height int64
+
+	// proofs caches the Merkle tree of the last height proven, see ProveAt.
+	proofMu sync.Mutex
+	proofs  *proofTree
+}
+
+// NewState opens the state stored in db, retaining versions for the last
+// keepRecent heights. Zero retains every version.
+func NewState(db dbm.DB, keepRecent int64) *State {
+	return &State{
+		db:          db,
+		pending:     make(map[string][]byte),
+		keepRecent:  keepRecent,
+		startPowers: make(map[string]int64),
+		numKeys:     -1,
+	}
+}
+
+// Get returns the value for key, including writes staged but not yet committed.
+func (s *State) Get(key []byte) ([]byte, error) {
+	if value, ok := s.pending[string(key)]; ok {
+		return value, nil
+	}
+	return s.GetCommitted(key)
+}
+
+// GetCommitted returns the last committed value for key, ignoring staged writes.
+func (s *State) GetCommitted(key []byte) ([]byte, error) {
+	return s.db.Get(stateKey(key))
+}
+
+// Set stages a write of value under key. It is persisted on the next Commit.
+func (s *State) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errors.New("key cannot be empty")
+	}
+	if s.batch == nil {
+		s.batch = s.db.NewBatch()
+	}
+	if err := s.batch.Set(stateKey(key), value); err != nil {
+		return err
+	}
+	s.pending[string(key)] = value
+	return nil
+}
+
+// SetMetadata stages a write of a raw, unversioned database key, such as the
+// last block height, so that it is persisted atomically with the next Commit.
+func (s *State) SetMetadata(key, value []byte) error {
+	if s.batch == nil {
+		s.batch = s.db.NewBatch()
+	}
+	return s.batch.Set(key, value)
+}
+
+// Iterate calls fn for every key/value pair in the state, including staged
+// writes, in ascending key order.
+func (s *State) Iterate(fn func(key, value []byte) error) error {
+	staged := make([]string, 0, len(s.pending))
+	for key := range s.pending {
+		staged = append(staged, key)
+	}
+	sort.Strings(staged)
+
+	it, err := s.db.Iterator(stateKeyPrefix, prefixEnd(stateKeyPrefix))
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	// Merge the committed and staged keys, letting staged values win.
+	for it.Valid() || len(staged) > 0 {
+		var key, value []byte
+		switch {
+		case !it.Valid():
+			key, staged = []byte(staged[0]), staged[1:]
+			value = s.pending[string(key)]
+		case len(staged) == 0 || bytes.Compare(it.Key()[len(stateKeyPrefix):], []byte(staged[0])) < 0:
+			key, value = it.Key()[len(stateKeyPrefix):], it.Value()
+			it.Next()
+		default:
+			if bytes.Equal(it.Key()[len(stateKeyPrefix):], []byte(staged[0])) {
+				it.Next()
+			}
+			key, staged = []byte(staged[0]), staged[1:]
+			value = s.pending[string(key)]
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// Hash returns the Merkle root of the state, including staged writes, used
+// as the AppHash. Leaves are built from the pairs in ascending key order, see
+// proof.go, so the result doesn't depend on the order of writes.
+func (s *State) Hash() ([]byte, error) {
+	var leaves [][]byte
+	err := s.Iterate(func(key, value []byte) error {
+		leaves = append(leaves, leafBytes(key, value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merkle.HashFromByteSlices(leaves), nil
+}
+
+// Commit durably writes all staged changes to the database as the state at
+// height, in a single batch. If the write fails nothing is persisted and the
+// changes stay staged.
+func (s *State) Commit(height int64) error {
+	if s.batch == nil {
+		s.batch = s.db.NewBatch()
+	}
+	if err := s.stageVersions(height); err != nil {
+		return err
+	}
+	newKeys := 0
+	if s.numKeys >= 0 {
+		for key := range s.pending {
+			exists, err := s.db.Has(stateKey([]byte(key)))
+			if err != nil {
+				return err
+			}
+			if !exists {
+				newKeys++
+			}
+		}
+	}
+	if err := s.batch.WriteSync(); err != nil {
+		return err
+	}
+	if s.numKeys >= 0 {
+		s.numKeys += newKeys
+	}
+	s.height = height
+	s.reset()
+	return nil
+}
+
+// Count returns the number of keys in the committed state.
+func (s *State) Count() (int, error) {
+	if s.numKeys >= 0 {
+		return s.numKeys, nil
+	}
+	it, err := s.db.Iterator(stateKeyPrefix, prefixEnd(stateKeyPrefix))
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	n := 0
+	for ; it.Valid(); it.Next() {
+		n++
+	}
+	if err := it.Error(); err != nil {
+		return 0, err
+	}
+	s.numKeys = n
+	return n, nil
+}
+
+// Discard drops all staged changes.
+func (s *State) Discard() {
+	s.reset()
+}
+
+func (s *State) reset() {
+	if s.batch != nil {
+		s.batch.Close()
+		s.batch = nil
+	}
+	s.pending = make(map[string][]byte)
+	s.startPowers = make(map[string]int64)
+}
+
+func stateKey(key []byte) []byte {
+	return append(append([]byte{}, stateKeyPrefix...), key...)
+}
+
+// prefixEnd returns the smallest key greater than every key starting with prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
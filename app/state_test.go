@@ -0,0 +1,101 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+func TestStateCommit(t *testing.T) {
+	db := dbm.NewMemDB()
+	state := NewState(db, 0)
+
+	blockGas := NewGasMeter(0)
+	for _, tx := range []string{"name=satoshi", "greeting=gm"} {
+		if res, _ := state.DeliverTX(KVTxDecoder{}, []byte(tx), blockGas); !res.IsOK() {
+			t.Fatalf("DeliverTX(%q): code %d, %s", tx, res.Code, res.Log)
+		}
+	}
+
+	// Staged writes are visible through Get, but not yet committed.
+	if value, err := state.Get([]byte("name")); err != nil || string(value) != "satoshi" {
+		t.Fatalf("Get(name) = %q, %v; want satoshi", value, err)
+	}
+	for _, key := range []string{"name", "greeting"} {
+		if value, err := state.GetCommitted([]byte(key)); err != nil || value != nil {
+			t.Errorf("GetCommitted(%s) before Commit = %q, %v; want nil", key, value, err)
+		}
+		if value, err := db.Get(stateKey([]byte(key))); err != nil || value != nil {
+			t.Errorf("db value of %s before Commit = %q, %v; want nil", key, value, err)
+		}
+	}
+
+	if err := state.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"name": "satoshi", "greeting": "gm"} {
+		if value, err := state.GetCommitted([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("GetCommitted(%s) = %q, %v; want %q", key, value, err, want)
+		}
+	}
+
+	// A fresh State over the same database sees the committed values.
+	if value, err := NewState(db, 0).Get([]byte("greeting")); err != nil || string(value) != "gm" {
+		t.Errorf("Get(greeting) after reopen = %q, %v; want gm", value, err)
+	}
+}
+
+// hashAfter applies txs to a fresh state and returns its hash.
+func hashAfter(t *testing.T, txs ...string) []byte {
+	t.Helper()
+	state := NewState(dbm.NewMemDB(), 0)
+	blockGas := NewGasMeter(0)
+	for _, tx := range txs {
+		if res, _ := state.DeliverTX(KVTxDecoder{}, []byte(tx), blockGas); !res.IsOK() {
+			t.Fatalf("DeliverTX(%q): code %d, %s", tx, res.Code, res.Log)
+		}
+	}
+	hash, err := state.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestHashChangesWithValue(t *testing.T) {
+	before := hashAfter(t, "name=satoshi", "greeting=gm")
+	after := hashAfter(t, "name=nakamoto", "greeting=gm")
+	if bytes.Equal(before, after) {
+		t.Errorf("hash %X unchanged after changing a value", before)
+	}
+}
+
+func TestHashIndependentOfTxOrder(t *testing.T) {
+	a := hashAfter(t, "name=satoshi", "greeting=gm", "x=1")
+	b := hashAfter(t, "x=1", "greeting=gm", "name=satoshi")
+	if !bytes.Equal(a, b) {
+		t.Errorf("hash depends on tx order: %X != %X", a, b)
+	}
+}
+
+func TestHashCommittedAndStaged(t *testing.T) {
+	// Committing doesn't change the hash of the state.
+	state := NewState(dbm.NewMemDB(), 0)
+	if err := state.Set([]byte("name"), []byte("satoshi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Set([]byte("greeting"), []byte("gm")); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := state.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hashAfter(t, "greeting=gm", "name=satoshi"); !bytes.Equal(hash, want) {
+		t.Errorf("hash = %X, want %X", hash, want)
+	}
+}
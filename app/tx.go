@@ -0,0 +1,138 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+)
+
+const (
+	// txSeparator splits a transaction into its key and value, e.g. "name=satoshi".
+	txSeparator = '='
+
+	// MaxTxSize is the largest raw transaction accepted, in bytes.
+	MaxTxSize = 64 * 1024
+
+	// GasPerByte is the gas charged for every key and value byte written.
+	GasPerByte = 10
+)
+
+// gasPrefix introduces an optional gas limit declared ahead of the tx body,
+// e.g. "gas:500:name=satoshi".
+var gasPrefix = []byte("gas:")
+
+var (
+	ErrEmptyTx          = errors.New("tx is empty")
+	ErrMissingSeparator = errors.New("tx must be in the form key=value")
+	ErrOversizedTx      = fmt.Errorf("tx exceeds %d bytes", MaxTxSize)
+	ErrInvalidGasLimit  = errors.New("tx gas limit must be in the form gas:<limit>:key=value")
+	ErrOutOfGas         = errors.New("tx gas limit is below the gas required")
+)
+
+// Tx is a decoded transaction: either a key/value write, or a validator update.
+type Tx struct {
+	Key   []byte
+	Value []byte
+	// ValidatorUpdate is set for validator txs, in which case Key and Value are empty.
+	ValidatorUpdate *abci.ValidatorUpdate
+	// GasLimit is the most gas the tx may use. Zero means no limit was declared.
+	GasLimit int64
+}
+
+// TxDecoder turns raw tx bytes into a Tx. CheckTx, PrepareProposal and
+// FinalizeBlock all decode through the same TxDecoder, so the wire format
+// can be swapped without touching the ABCI methods.
+type TxDecoder interface {
+	Decode(raw []byte) (Tx, error)
+}
+
+// KVTxDecoder is the default TxDecoder, for txs in the form
+//
+//	[gas:<limit>:]key=value
+//	[gas:<limit>:]val:<pubkey_base64>:<power>
+type KVTxDecoder struct{}
+
+func (KVTxDecoder) Decode(raw []byte) (Tx, error) {
+	if err := checkRawTx(raw); err != nil {
+		return Tx{}, err
+	}
+
+	var gasLimit int64
+	if rest, ok := bytes.CutPrefix(raw, gasPrefix); ok {
+		limit, body, found := bytes.Cut(rest, []byte{':'})
+		if !found {
+			return Tx{}, ErrInvalidGasLimit
+		}
+		n, err := strconv.ParseInt(string(limit), 10, 64)
+		if err != nil || n <= 0 {
+			return Tx{}, ErrInvalidGasLimit
+		}
+		gasLimit, raw = n, body
+	}
+
+	if body, ok := bytes.CutPrefix(raw, validatorPrefix); ok {
+		update, err := parseValidatorTx(body)
+		if err != nil {
+			return Tx{}, err
+		}
+		return Tx{ValidatorUpdate: update, GasLimit: gasLimit}, nil
+	}
+
+	key, value, found := bytes.Cut(raw, []byte{txSeparator})
+	if !found {
+		return Tx{}, ErrMissingSeparator
+	}
+	tx := Tx{Key: key, Value: value, GasLimit: gasLimit}
+	return tx, tx.validate()
+}
+
+// checkRawTx applies the checks every decoder shares to the raw tx bytes.
+func checkRawTx(raw []byte) error {
+	if len(raw) == 0 {
+		return ErrEmptyTx
+	}
+	if len(raw) > MaxTxSize {
+		return ErrOversizedTx
+	}
+	return nil
+}
+
+// validate checks a decoded key/value tx.
+func (tx Tx) validate() error {
+	if len(tx.Key) == 0 {
+		return errors.New("tx key cannot be empty")
+	}
+	for _, prefix := range [][]byte{validatorPrefix, paramsPrefix} {
+		if bytes.HasPrefix(tx.Key, prefix) {
+			return fmt.Errorf("tx key cannot start with reserved prefix %q", prefix)
+		}
+	}
+	return nil
+}
+
+// Gas returns the gas needed to write the transaction into the state.
+func (tx Tx) Gas() int64 {
+	if tx.ValidatorUpdate != nil {
+		return int64(len(tx.ValidatorUpdate.PubKeyBytes)+8) * GasPerByte
+	}
+	return int64(len(tx.Key)+len(tx.Value)) * GasPerByte
+}
+
+// GasWanted returns the declared gas limit, or the gas required if none was declared.
+func (tx Tx) GasWanted() int64 {
+	if tx.GasLimit > 0 {
+		return tx.GasLimit
+	}
+	return tx.Gas()
+}
+
+// CheckGas reports whether the tx declared enough gas to pay for itself.
+func (tx Tx) CheckGas() error {
+	if tx.GasLimit > 0 && tx.Gas() > tx.GasLimit {
+		return fmt.Errorf("%w: limit %d, required %d", ErrOutOfGas, tx.GasLimit, tx.Gas())
+	}
+	return nil
+}
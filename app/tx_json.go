@@ -0,0 +1,64 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// JSONTxDecoder is a TxDecoder for JSON txs, equivalent to the KVTxDecoder formats:
+//
+//	{"key": "name", "value": "satoshi", "gas_limit": 500}
+//	{"validator": {"pub_key": "<pubkey_base64>", "power": 10}}
+//
+// gas_limit is optional in both.
+type JSONTxDecoder struct{}
+
+type jsonTx struct {
+	Key       string         `json:"key"`
+	Value     string         `json:"value"`
+	GasLimit  int64          `json:"gas_limit"`
+	Validator *jsonValidator `json:"validator"`
+}
+
+type jsonValidator struct {
+	PubKey []byte `json:"pub_key"`
+	Power  *int64 `json:"power"`
+}
+
+func (JSONTxDecoder) Decode(raw []byte) (Tx, error) {
+	if err := checkRawTx(raw); err != nil {
+		return Tx{}, err
+	}
+
+	var jtx jsonTx
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&jtx); err != nil {
+		return Tx{}, fmt.Errorf("decoding json tx: %w", err)
+	}
+	if dec.More() {
+		return Tx{}, errors.New("decoding json tx: unexpected data after tx")
+	}
+	if jtx.GasLimit < 0 {
+		return Tx{}, ErrInvalidGasLimit
+	}
+
+	if val := jtx.Validator; val != nil {
+		if jtx.Key != "" || jtx.Value != "" {
+			return Tx{}, errors.New("tx cannot be both a validator update and a key/value write")
+		}
+		if val.Power == nil {
+			return Tx{}, fmt.Errorf("%w: missing power", ErrInvalidValidatorTx)
+		}
+		update, err := newValidatorUpdate(val.PubKey, *val.Power)
+		if err != nil {
+			return Tx{}, err
+		}
+		return Tx{ValidatorUpdate: update, GasLimit: jtx.GasLimit}, nil
+	}
+
+	tx := Tx{Key: []byte(jtx.Key), Value: []byte(jtx.Value), GasLimit: jtx.GasLimit}
+	return tx, tx.validate()
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/ed25519"
+)
+
+func TestJSONTxDecoderMatchesKV(t *testing.T) {
+	genesis := abci.NewValidatorUpdate(ed25519.GenPrivKeyFromSecret([]byte{1}).PubKey(), 10)
+	pubKey := base64.StdEncoding.EncodeToString(ed25519.GenPrivKeyFromSecret([]byte{2}).PubKey().Bytes())
+
+	// Each pair is the same tx in both formats.
+	txs := []struct{ kv, json string }{
+		{"name=satoshi", `{"key": "name", "value": "satoshi"}`},
+		{"gas:500:greeting=gm", `{"key": "greeting", "value": "gm", "gas_limit": 500}`},
+		{"gas:10:x=1", `{"key": "x", "value": "1", "gas_limit": 10}`},
+		{"val:" + pubKey + ":5", `{"validator": {"pub_key": "` + pubKey + `", "power": 5}}`},
+		{"gas:1000:val:" + pubKey + ":7", `{"validator": {"pub_key": "` + pubKey + `", "power": 7}, "gas_limit": 1000}`},
+	}
+
+	apply := func(decoder TxDecoder, json bool) ([]*abci.ExecTxResult, []byte) {
+		state := NewState(dbm.NewMemDB(), 0)
+		if err := state.InitValidators([]abci.ValidatorUpdate{genesis}); err != nil {
+			t.Fatal(err)
+		}
+		blockGas := NewGasMeter(0)
+		var results []*abci.ExecTxResult
+		for _, tx := range txs {
+			raw := tx.kv
+			if json {
+				raw = tx.json
+			}
+			res, _ := state.DeliverTX(decoder, []byte(raw), blockGas)
+			results = append(results, res)
+		}
+		hash, err := state.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results, hash
+	}
+
+	kvResults, kvHash := apply(KVTxDecoder{}, false)
+	jsonResults, jsonHash := apply(JSONTxDecoder{}, true)
+	for i, tx := range txs {
+		kv, json := kvResults[i], jsonResults[i]
+		if kv.Code != json.Code || kv.GasWanted != json.GasWanted || kv.GasUsed != json.GasUsed {
+			t.Errorf("%s: kv code %d gas %d/%d, json code %d gas %d/%d", tx.json,
+				kv.Code, kv.GasUsed, kv.GasWanted, json.Code, json.GasUsed, json.GasWanted)
+		}
+	}
+	for i, want := range []uint32{CodeTypeOK, CodeTypeOK, CodeTypeOutOfGas, CodeTypeOK, CodeTypeOK} {
+		if code := jsonResults[i].Code; code != want {
+			t.Errorf("%s: code %d, want %d: %s", txs[i].json, code, want, jsonResults[i].Log)
+		}
+	}
+	if !bytes.Equal(kvHash, jsonHash) {
+		t.Errorf("kv hash %X != json hash %X", kvHash, jsonHash)
+	}
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	"github.com/cometbft/cometbft/types"
+)
+
+// validatorPrefix introduces a validator update tx, "val:<pubkey_base64>:<power>",
+// and is also the state key prefix under which the validator set is kept:
+//
+//	val:<pubkey_base64> = <power>
+//
+// A power of zero removes the validator. Key/value txs can't write these keys,
+// see Tx.validate.
+//
+// Validator txs are a demo and are NOT authenticated: anyone who can submit a
+// tx can add, reweight or remove any validator, and so take over the chain.
+// A real app must check that such a tx is signed by an authorized party, or
+// derive validator updates from its own logic (e.g. staking) instead.
+var validatorPrefix = []byte("val:")
+
+var ErrInvalidValidatorTx = errors.New("invalid validator tx")
+
+// parseValidatorTx decodes the body of a validator tx, after validatorPrefix.
+func parseValidatorTx(body []byte) (*abci.ValidatorUpdate, error) {
+	pubKeyStr, powerStr, found := bytes.Cut(body, []byte{':'})
+	if !found {
+		return nil, fmt.Errorf("%w: must be in the form val:<pubkey_base64>:<power>", ErrInvalidValidatorTx)
+	}
+	pubKey, err := base64.StdEncoding.DecodeString(string(pubKeyStr))
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding pubkey: %v", ErrInvalidValidatorTx, err)
+	}
+	power, err := strconv.ParseInt(string(powerStr), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid power %q", ErrInvalidValidatorTx, powerStr)
+	}
+	return newValidatorUpdate(pubKey, power)
+}
+
+// newValidatorUpdate validates a decoded ed25519 pubkey and voting power.
+func newValidatorUpdate(pubKey []byte, power int64) (*abci.ValidatorUpdate, error) {
+	if len(pubKey) != ed25519.PubKeySize {
+		return nil, fmt.Errorf("%w: pubkey must be %d bytes, got %d", ErrInvalidValidatorTx, ed25519.PubKeySize, len(pubKey))
+	}
+	if power < 0 || power > types.MaxTotalVotingPower {
+		return nil, fmt.Errorf("%w: invalid power %d", ErrInvalidValidatorTx, power)
+	}
+	update := abci.NewValidatorUpdate(ed25519.PubKey(pubKey), power)
+	return &update, nil
+}
+
+// InitValidators records the genesis validator set.
+func (s *State) InitValidators(validators []abci.ValidatorUpdate) error {
+	for _, val := range validators {
+		if err := s.Set(validatorKey(val.PubKeyBytes), []byte(strconv.FormatInt(val.Power, 10))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateValidator stages a change to the validator set, rejecting updates
+// that CometBFT would refuse to apply: removing a validator that isn't in the
+// set, removing the last one, or pushing the total power over
+// types.MaxTotalVotingPower.
+//
+// CometBFT applies a block's updates to the set the block started with, so a
+// validator added in a block can't be removed until the next one: the add and
+// the removal would be merged into the removal of an unknown validator.
+func (s *State) UpdateValidator(update abci.ValidatorUpdate) error {
+	key := validatorKey(update.PubKeyBytes)
+	bz, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	power, err := parsePower(bz)
+	if err != nil {
+		return err
+	}
+	startPower, ok := s.startPowers[string(key)]
+	if !ok {
+		startPower = power
+	}
+
+	n, total, err := s.validatorSet()
+	if err != nil {
+		return err
+	}
+	if update.Power == 0 {
+		if power == 0 {
+			return fmt.Errorf("%w: validator %X is not in the set", ErrInvalidValidatorTx, update.PubKeyBytes)
+		}
+		if startPower == 0 {
+			return fmt.Errorf("%w: validator %X was added in this block and can't be removed before the next", ErrInvalidValidatorTx, update.PubKeyBytes)
+		}
+		if n <= 1 {
+			return fmt.Errorf("%w: cannot remove the last validator", ErrInvalidValidatorTx)
+		}
+	}
+	if total = total - power + update.Power; total > types.MaxTotalVotingPower {
+		return fmt.Errorf("%w: total voting power %d would exceed %d", ErrInvalidValidatorTx, total, types.MaxTotalVotingPower)
+	}
+
+	if err := s.Set(key, []byte(strconv.FormatInt(update.Power, 10))); err != nil {
+		return err
+	}
+	s.startPowers[string(key)] = startPower
+	return nil
+}
+
+// validatorSet returns the number of validators with non-zero power, and
+// their total power.
+func (s *State) validatorSet() (n int, total int64, err error) {
+	err = s.Iterate(func(key, value []byte) error {
+		if !bytes.HasPrefix(key, validatorPrefix) {
+			return nil
+		}
+		power, err := parsePower(value)
+		if err != nil {
+			return err
+		}
+		if power > 0 {
+			n++
+			total += power
+		}
+		return nil
+	})
+	return n, total, err
+}
+
+// parsePower decodes a stored voting power. A validator never set has none.
+func parsePower(bz []byte) (int64, error) {
+	if len(bz) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(bz), 10, 64)
+}
+
+func validatorKey(pubKey []byte) []byte {
+	return append(append([]byte{}, validatorPrefix...), base64.StdEncoding.EncodeToString(pubKey)...)
+}
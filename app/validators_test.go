@@ -0,0 +1,82 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	"github.com/cometbft/cometbft/types"
+)
+
+func testValidator(seed byte, power int64) abci.ValidatorUpdate {
+	return abci.NewValidatorUpdate(ed25519.GenPrivKeyFromSecret([]byte{seed}).PubKey(), power)
+}
+
+func TestUpdateValidatorAddThenRemove(t *testing.T) {
+	state := NewState(dbm.NewMemDB(), 0)
+	if err := state.InitValidators([]abci.ValidatorUpdate{testValidator(1, 10), testValidator(2, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.UpdateValidator(testValidator(3, 5)); err != nil {
+		t.Fatal(err)
+	}
+	// CometBFT would see the removal of a validator it never added.
+	if err := state.UpdateValidator(testValidator(3, 0)); !errors.Is(err, ErrInvalidValidatorTx) {
+		t.Fatalf("removing a validator added in the same block = %v, want ErrInvalidValidatorTx", err)
+	}
+	// Reweighting it is fine, as is removing a validator from the start of the block.
+	if err := state.UpdateValidator(testValidator(3, 7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.UpdateValidator(testValidator(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// Neither is undone by changing them again in the same block.
+	if err := state.UpdateValidator(testValidator(2, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.UpdateValidator(testValidator(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := state.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.UpdateValidator(testValidator(3, 0)); err != nil {
+		t.Errorf("removing the validator in the next block = %v", err)
+	}
+}
+
+func TestUpdateValidatorTotalPower(t *testing.T) {
+	state := NewState(dbm.NewMemDB(), 0)
+	if err := state.InitValidators([]abci.ValidatorUpdate{testValidator(1, 10), testValidator(2, 10)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		update abci.ValidatorUpdate
+		ok     bool
+	}{
+		{"new validator over the cap", testValidator(3, types.MaxTotalVotingPower-19), false},
+		{"raise over the cap", testValidator(1, types.MaxTotalVotingPower-9), false},
+		{"new validator at the cap", testValidator(3, types.MaxTotalVotingPower-20), true},
+		{"any more power", testValidator(4, 1), false},
+		{"lower, then add", testValidator(3, types.MaxTotalVotingPower-21), true},
+		{"add up to the cap", testValidator(4, 1), true},
+	}
+	for _, tt := range tests {
+		err := state.UpdateValidator(tt.update)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidValidatorTx) {
+			t.Errorf("%s: err = %v, want ErrInvalidValidatorTx", tt.name, err)
+		}
+	}
+	if _, total, err := state.validatorSet(); err != nil || total != types.MaxTotalVotingPower {
+		t.Errorf("total power = %d, %v; want %d", total, err, types.MaxTotalVotingPower)
+	}
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+// Alongside the latest value under stateKeyPrefix, every committed write is
+// kept under versionKeyPrefix as
+//
+//	v: | len(key) (4 bytes BE) | key | height (8 bytes BE)
+//
+// so the value of a key at height H is its highest version <= H. Versions
+// below earliestHeightKey have been pruned and can no longer be read.
+//
+// Every version is also indexed by height, under versionIndexPrefix as
+//
+//	vh: | height (8 bytes BE) | key
+//
+// so that pruning only visits the writes of the heights leaving the window.
+var (
+	versionKeyPrefix   = []byte("v:")
+	versionIndexPrefix = []byte("vh:")
+	earliestHeightKey  = []byte("earliestHeight")
+)
+
+var ErrHeightPruned = errors.New("height has been pruned")
+
+// GetAt returns the committed value for key as of height.
+func (s *State) GetAt(key []byte, height int64) ([]byte, error) {
+	earliest, err := s.EarliestHeight()
+	if err != nil {
+		return nil, err
+	}
+	if height < earliest {
+		return nil, fmt.Errorf("%w: %d is below the earliest retained height %d", ErrHeightPruned, height, earliest)
+	}
+
+	it, err := s.db.ReverseIterator(versionKey(key, 0), versionKey(key, height+1))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	if !it.Valid() {
+		return nil, it.Error()
+	}
+	return it.Value(), nil
+}
+
+// StateView is a read-only view of the committed state as of one height.
+type StateView struct {
+	it     dbm.Iterator
+	height int64
+}
+
+// ViewAt opens a view of the committed state as of height. The view reads
+// through a single database iterator opened here, which cometbft-db backends
+// serve from a point-in-time snapshot, so it is unaffected by later commits.
+// It must be closed after use.
+func (s *State) ViewAt(height int64) (*StateView, error) {
+	earliest, err := s.EarliestHeight()
+	if err != nil {
+		return nil, err
+	}
+	if height < earliest {
+		return nil, fmt.Errorf("%w: %d is below the earliest retained height %d", ErrHeightPruned, height, earliest)
+	}
+	it, err := s.db.Iterator(versionKeyPrefix, prefixEnd(versionKeyPrefix))
+	if err != nil {
+		return nil, err
+	}
+	return &StateView{it: it, height: height}, nil
+}
+
+// Iterate calls fn for every key/value pair in the view, ordered by key
+// length and then key. It can only be called once.
+func (v *StateView) Iterate(fn func(key, value []byte) error) error {
+	var key, value []byte
+	for ; v.it.Valid(); v.it.Next() {
+		k, height := splitVersionKey(v.it.Key())
+		if key != nil && !bytes.Equal(k, key) {
+			if err := fn(key, value); err != nil {
+				return err
+			}
+			key, value = nil, nil
+		}
+		// Versions of a key are ordered by height, so the last one <= the
+		// view's height is its value.
+		if height <= v.height {
+			key, value = append([]byte{}, k...), append([]byte{}, v.it.Value()...)
+		}
+	}
+	if err := v.it.Error(); err != nil {
+		return err
+	}
+	if key != nil {
+		return fn(key, value)
+	}
+	return nil
+}
+
+// Close releases the view's iterator.
+func (v *StateView) Close() error {
+	return v.it.Close()
+}
+
+// EarliestHeight returns the lowest height that can still be read with GetAt,
+// or zero if nothing has been committed.
+func (s *State) EarliestHeight() (int64, error) {
+	bz, err := s.db.Get(earliestHeightKey)
+	if err != nil || len(bz) == 0 {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(bz)), nil
+}
+
+// stageVersions adds the pending writes as versions at height to the batch,
+// and prunes versions that fall out of the retention window.
+func (s *State) stageVersions(height int64) error {
+	for key, value := range s.pending {
+		if err := s.batch.Set(versionKey([]byte(key), height), value); err != nil {
+			return err
+		}
+		if err := s.batch.Set(versionIndexKey(height, []byte(key)), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	earliest, err := s.EarliestHeight()
+	if err != nil {
+		return err
+	}
+	newEarliest := earliest
+	if earliest == 0 {
+		// First commit, or the first after restoring a snapshot: nothing older exists.
+		newEarliest = height
+	}
+	if s.keepRecent > 0 && height-s.keepRecent+1 > newEarliest {
+		newEarliest = height - s.keepRecent + 1
+		if err := s.pruneVersions(earliest, newEarliest); err != nil {
+			return err
+		}
+	}
+	if newEarliest == earliest {
+		return nil
+	}
+	var hb [8]byte
+	binary.BigEndian.PutUint64(hb[:], uint64(newEarliest))
+	return s.batch.Set(earliestHeightKey, hb[:])
+}
+
+// pruneVersions stages the deletion of the versions no longer needed once
+// the earliest readable height moves from `from` up to `to`. A version
+// written at a height h in [from, to) shadows every older version of its key
+// for all heights >= to, so those are deleted along with h's index entries.
+// The version at h itself may still be the one read at `to`, and is pruned
+// once a later write to the key leaves the window.
+func (s *State) pruneVersions(from, to int64) error {
+	it, err := s.db.Iterator(versionIndexKey(from, nil), versionIndexKey(to, nil))
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for ; it.Valid(); it.Next() {
+		height, key := splitVersionIndexKey(it.Key())
+		older, err := s.db.Iterator(versionKey(key, 0), versionKey(key, height))
+		if err != nil {
+			return err
+		}
+		for ; older.Valid(); older.Next() {
+			if err := s.batch.Delete(append([]byte{}, older.Key()...)); err != nil {
+				older.Close()
+				return err
+			}
+		}
+		if err := older.Error(); err != nil {
+			older.Close()
+			return err
+		}
+		older.Close()
+		if err := s.batch.Delete(append([]byte{}, it.Key()...)); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+func versionKey(key []byte, height int64) []byte {
+	bz := make([]byte, 0, len(versionKeyPrefix)+4+len(key)+8)
+	bz = append(bz, versionKeyPrefix...)
+	bz = binary.BigEndian.AppendUint32(bz, uint32(len(key)))
+	bz = append(bz, key...)
+	return binary.BigEndian.AppendUint64(bz, uint64(height))
+}
+
+func splitVersionKey(bz []byte) ([]byte, int64) {
+	bz = bz[len(versionKeyPrefix):]
+	n := binary.BigEndian.Uint32(bz)
+	key := bz[4 : 4+n]
+	return key, int64(binary.BigEndian.Uint64(bz[4+n:]))
+}
+
+func versionIndexKey(height int64, key []byte) []byte {
+	bz := make([]byte, 0, len(versionIndexPrefix)+8+len(key))
+	bz = append(bz, versionIndexPrefix...)
+	bz = binary.BigEndian.AppendUint64(bz, uint64(height))
+	return append(bz, key...)
+}
+
+func splitVersionIndexKey(bz []byte) (int64, []byte) {
+	bz = bz[len(versionIndexPrefix):]
+	return int64(binary.BigEndian.Uint64(bz)), bz[8:]
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+)
+
+// commitAt stages key=value and commits it as height.
+func commitAt(t *testing.T, state *State, height int64, key, value string) {
+	t.Helper()
+	if key != "" {
+		if err := state.Set([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := state.Commit(height); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAt(t *testing.T) {
+	state := NewState(dbm.NewMemDB(), 0)
+	commitAt(t, state, 1, "other", "x")
+	for h := int64(2); h <= 10; h++ {
+		switch h {
+		case 5:
+			commitAt(t, state, h, "name", "satoshi")
+		case 10:
+			commitAt(t, state, h, "name", "nakamoto")
+		default:
+			commitAt(t, state, h, "", "")
+		}
+	}
+
+	for height, want := range map[int64]string{1: "", 4: "", 5: "satoshi", 7: "satoshi", 9: "satoshi", 10: "nakamoto"} {
+		value, err := state.GetAt([]byte("name"), height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != want {
+			t.Errorf("name at height %d = %q, want %q", height, value, want)
+		}
+	}
+}
+
+func TestGetAtPruned(t *testing.T) {
+	db := dbm.NewMemDB()
+	state := NewState(db, 3)
+	for h := int64(1); h <= 10; h++ {
+		commitAt(t, state, h, "name", strconv.FormatInt(h, 10))
+	}
+
+	if earliest, err := state.EarliestHeight(); err != nil || earliest != 8 {
+		t.Fatalf("EarliestHeight = %d, %v; want 8", earliest, err)
+	}
+	for h := int64(8); h <= 10; h++ {
+		if value, err := state.GetAt([]byte("name"), h); err != nil || string(value) != strconv.FormatInt(h, 10) {
+			t.Errorf("name at height %d = %q, %v", h, value, err)
+		}
+	}
+	if _, err := state.GetAt([]byte("name"), 7); !errors.Is(err, ErrHeightPruned) {
+		t.Errorf("GetAt below the window = %v, want ErrHeightPruned", err)
+	}
+	res, err := state.QueryData(&abci.QueryRequest{Path: QueryPathStore, Data: []byte("name")}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != CodeTypeHeightPruned {
+		t.Errorf("query below the window: code %d, want %d", res.Code, CodeTypeHeightPruned)
+	}
+
+	// Pruning keeps the window plus the version written just below it, which
+	// goes once the write at height 8 leaves the window.
+	for prefix, want := range map[string]int{"v:": 4, "vh:": 3} {
+		if n := countPrefix(t, db, []byte(prefix)); n != want {
+			t.Errorf("%d keys under %q, want %d", n, prefix, want)
+		}
+	}
+}
+
+func TestPruneKeepsShadowingVersion(t *testing.T) {
+	state := NewState(dbm.NewMemDB(), 2)
+	commitAt(t, state, 1, "name", "satoshi")
+	for h := int64(2); h <= 6; h++ {
+		commitAt(t, state, h, "other", strconv.FormatInt(h, 10))
+	}
+	// Written long before the window, but still its value inside it.
+	if value, err := state.GetAt([]byte("name"), 5); err != nil || string(value) != "satoshi" {
+		t.Errorf("name at height 5 = %q, %v; want satoshi", value, err)
+	}
+}
+
+func countPrefix(t *testing.T, db dbm.DB, prefix []byte) int {
+	t.Helper()
+	it, err := db.Iterator(prefix, prefixEnd(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	n := 0
+	for ; it.Valid(); it.Next() {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,41 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"cometbft-baseapp/comet"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var chainID string
+var force bool
+
+// initCmd scaffolds a new node home directory
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create the config, genesis and keys for a new node",
+	Long: `Create the home directory for a new node with a default config.toml and
+app.toml, a fresh node key and validator key, and a single-validator genesis.json.
+
+An existing home is left untouched unless --force is passed.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := comet.InitHome(homeDir, chainID, force); err != nil {
+			return err
+		}
+		fmt.Printf("Initialized node home at %s\n", homeDir)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().StringVar(&chainID, "chain-id", "baseapp-local", "chain ID written to genesis.json")
+	initCmd.Flags().BoolVarP(&force, "force", "f", false,
+		"overwrite an existing home directory, including priv_validator_state.json: "+
+			"never use on a live validator, as losing its last signed height risks double-signing")
+}
@@ -6,6 +6,7 @@ package cmd
 import (
 	"cometbft-baseapp/comet"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 )
@@ -40,6 +41,14 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&homeDir, "home", "d", "", "home directory for configuration (default is $HOME/.cometbft)")
+	rootCmd.PersistentFlags().StringVarP(&homeDir, "home", "d", defaultHomeDir(), "home directory for configuration")
 	rootCmd.Flags().StringVarP(&logLevel, "log-level", "l", "info", "log level for the application (default is 'info', options: debug, info, warn, error, none)")
 }
+
+func defaultHomeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".cometbft")
+}
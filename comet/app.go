@@ -1,10 +1,15 @@
 package comet
 
 import (
+	"cometbft-baseapp/app"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	dbm "github.com/cometbft/cometbft-db"
 	cfg "github.com/cometbft/cometbft/config"
@@ -14,6 +19,7 @@ import (
 	"github.com/cometbft/cometbft/p2p"
 	"github.com/cometbft/cometbft/privval"
 	"github.com/cometbft/cometbft/proxy"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func Run(logLevel *string, dir *string) {
@@ -44,18 +50,44 @@ func Run(logLevel *string, dir *string) {
 		log.Fatalf("failed to load node's key: %v", err)
 	}
 
+	appConfig, err := LoadAppConfig(appConfigFile(config))
+	if err != nil {
+		log.Fatalf("failed to load app config: %v", err)
+	}
+	if !filepath.IsAbs(appConfig.SnapshotDir) {
+		appConfig.SnapshotDir = filepath.Join(config.RootDir, appConfig.SnapshotDir)
+	}
+
 	// Init Database
-	appDB, err := dbm.NewDB("app", dbm.BackendType(config.DBBackend), config.DBDir())
+	appDB, err := dbm.NewDB("app", dbm.BackendType(appConfig.DBBackend), config.DBDir())
 	if err != nil {
 		log.Fatalf("failed to create database: %v", err)
 	}
+	defer func() {
+		if err := appDB.Close(); err != nil {
+			logger.Error("Closing app database", "err", err)
+		}
+	}()
 
 	// Create the application instance
-	app := NewCometApp(appDB)
+	metrics := NewMetrics(config.Instrumentation.Namespace)
+	if config.Instrumentation.Prometheus {
+		// CometBFT serves the default registry on Instrumentation.PrometheusListenAddr.
+		if err := metrics.Register(prometheus.DefaultRegisterer); err != nil {
+			log.Fatalf("failed to register app metrics: %v", err)
+		}
+	}
+	app, err := NewCometApp(appDB, appConfig, metrics, app.KVTxDecoder{})
+	if err != nil {
+		log.Fatalf("failed to create application: %v", err)
+	}
+	defer app.WaitSnapshots()
 
-	// Create the CometBFT node
-	ctx, cancel := context.WithCancel(context.Background())
+	// Cancel the context on SIGINT/SIGTERM so the deferred cleanup runs.
+	ctx, cancel := shutdownOnSignal(context.Background(), logger)
 	defer cancel()
+
+	// Create the CometBFT node
 	node, err := nm.NewNode(
 		ctx,
 		config,
@@ -84,6 +116,24 @@ func Run(logLevel *string, dir *string) {
 	log.Println("Received shutdown signal, stopping all services...")
 }
 
+// shutdownOnSignal returns a copy of ctx that is cancelled on SIGINT or
+// SIGTERM, or when the returned cancel func is called.
+func shutdownOnSignal(ctx context.Context, logger cmtlog.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigCh)
+		select {
+		case sig := <-sigCh:
+			logger.Info("Received signal", "signal", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
 func GetLastBlockHashAndHeight(db dbm.DB) ([]byte, int64) {
 	lastHash, _ := db.Get([]byte("lastAppHash"))
 	heightBytes, _ := db.Get([]byte("lastHeight"))
@@ -92,7 +142,11 @@ func GetLastBlockHashAndHeight(db dbm.DB) ([]byte, int64) {
 	fmt.Println("Last Block Height Bytes:", heightBytes)
 
 	height := int64(0)
-	if len(heightBytes) > 0 {
+	switch len(heightBytes) {
+	case 8:
+		height = int64(binary.BigEndian.Uint64(heightBytes))
+	case 1:
+		// Older versions persisted the height as a single (truncated) byte.
 		height = int64(heightBytes[0])
 	}
 	return lastHash, height
@@ -0,0 +1,78 @@
+package comet
+
+import (
+	"bytes"
+	"cometbft-baseapp/app"
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	dbm "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+	cmtlog "github.com/cometbft/cometbft/libs/log"
+)
+
+func TestGetLastBlockHashAndHeight(t *testing.T) {
+	dir := t.TempDir()
+	db, err := dbm.NewDB("app", dbm.PebbleDBBackend, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultAppConfig()
+	config.SnapshotDir = t.TempDir()
+	cometApp, err := NewCometApp(db, config, NewMetrics("test"), app.KVTxDecoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 300 doesn't fit in one byte, which is what older versions truncated it to.
+	ctx := context.Background()
+	res, err := cometApp.FinalizeBlock(ctx, &abci.FinalizeBlockRequest{Height: 300, Txs: [][]byte{[]byte("a=b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cometApp.Commit(ctx, &abci.CommitRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = dbm.NewDB("app", dbm.PebbleDBBackend, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	hash, height := GetLastBlockHashAndHeight(db)
+	if height != 300 {
+		t.Errorf("height = %d, want 300", height)
+	}
+	if !bytes.Equal(hash, res.AppHash) {
+		t.Errorf("hash = %X, want %X", hash, res.AppHash)
+	}
+}
+
+func TestGetLastBlockHashAndHeightLegacyFormat(t *testing.T) {
+	db := dbm.NewMemDB()
+	if err := db.Set([]byte("lastHeight"), []byte{42}); err != nil {
+		t.Fatal(err)
+	}
+	if _, height := GetLastBlockHashAndHeight(db); height != 42 {
+		t.Errorf("height = %d, want 42", height)
+	}
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	ctx, cancel := shutdownOnSignal(context.Background(), cmtlog.NewNopLogger())
+	defer cancel()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled after SIGTERM")
+	}
+}
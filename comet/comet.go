@@ -1,38 +1,143 @@
 package comet
 
 import (
+	"bytes"
 	"cometbft-baseapp/app"
 	"context"
-	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	dbm "github.com/cometbft/cometbft-db"
 	abci "github.com/cometbft/cometbft/abci/types"
 	v1 "github.com/cometbft/cometbft/api/cometbft/types/v1"
+	"github.com/cometbft/cometbft/crypto/tmhash"
+	"github.com/cometbft/cometbft/types"
 )
 
 type CometApp struct {
-	db         dbm.DB
-	lastHash   []byte
-	lastHeight int64
+	db        dbm.DB
+	state     *app.State
+	config    *AppConfig
+	metrics   *Metrics
+	decoder   app.TxDecoder
+	snapshots *app.SnapshotStore
+	restore   *app.SnapshotRestore
+	// snapshotting is held while a snapshot is written in the background.
+	snapshotting sync.Mutex
+	lastHash     []byte
+	lastHeight   int64
+	// pendingHash and pendingHeight describe the block executed by
+	// FinalizeBlock, until Commit persists it.
+	pendingHash   []byte
+	pendingHeight int64
+	// consensusMaxBytes and consensusMaxGas are the block size and gas limits
+	// from the chain's consensus params, kept in the state (see
+	// app.BlockParams). A MaxGas of -1 means unlimited.
+	consensusMaxBytes int64
+	consensusMaxGas   int64
 }
 
-func NewCometApp(db dbm.DB) *CometApp {
+func NewCometApp(db dbm.DB, config *AppConfig, metrics *Metrics, decoder app.TxDecoder) (*CometApp, error) {
+	snapshots, err := app.NewSnapshotStore(config.SnapshotDir, config.SnapshotKeepRecent)
+	if err != nil {
+		return nil, fmt.Errorf("opening snapshot store: %w", err)
+	}
+
 	lastBlockHash, height := GetLastBlockHashAndHeight(db)
-	return &CometApp{
+	cometApp := &CometApp{
 		db:         db,
+		state:      app.NewState(db, config.StateKeepRecent),
+		config:     config,
+		metrics:    metrics,
+		decoder:    decoder,
+		snapshots:  snapshots,
 		lastHash:   lastBlockHash,
 		lastHeight: height,
 	}
+	// InitChain only runs once, so after a restart the limits come from the state.
+	if err := cometApp.loadBlockParams(); err != nil {
+		return nil, err
+	}
+	return cometApp, nil
+}
+
+// MaxTxBytes is the most tx bytes a block may carry under the chain's
+// consensus block size. It must not depend on node config, since every
+// validator has to agree on which proposals are valid. The block size is read
+// from the state, so a node restored from a snapshot agrees as well.
+func (cometApp *CometApp) MaxTxBytes() int64 {
+	if cometApp.consensusMaxBytes > 0 {
+		return cometApp.consensusMaxBytes
+	}
+	return types.DefaultBlockParams().MaxBytes
+}
+
+// updateConsensusParams stages the consensus block size and gas limits in
+// the state, and starts enforcing them. The app never updates the consensus
+// params itself, so after InitChain they only change if the chain is
+// upgraded to a version that does.
+func (cometApp *CometApp) updateConsensusParams(params *v1.ConsensusParams) error {
+	if params == nil || params.Block == nil {
+		return nil
+	}
+	blockParams := app.BlockParams{MaxBytes: params.Block.MaxBytes, MaxGas: params.Block.MaxGas}
+	if err := cometApp.state.SetBlockParams(blockParams); err != nil {
+		return err
+	}
+	cometApp.setBlockParams(blockParams)
+	return nil
+}
+
+// loadBlockParams starts enforcing the block limits recorded in the state.
+func (cometApp *CometApp) loadBlockParams() error {
+	params, err := cometApp.state.BlockParams()
+	if err != nil {
+		return fmt.Errorf("loading block params: %w", err)
+	}
+	cometApp.setBlockParams(params)
+	return nil
+}
+
+func (cometApp *CometApp) setBlockParams(params app.BlockParams) {
+	maxBytes := params.MaxBytes
+	if maxBytes == -1 {
+		maxBytes = types.MaxBlockSizeBytes
+	}
+	cometApp.consensusMaxBytes = maxBytes
+	cometApp.consensusMaxGas = params.MaxGas
 }
 
 // ------------------------
 // InitChain
 // ------------------------
 
+// InitChain: this is called once, before the first block, to seed the state from genesis.
 func (cometApp *CometApp) InitChain(ctx context.Context, req *abci.InitChainRequest) (*abci.InitChainResponse, error) {
-	return &abci.InitChainResponse{}, nil
+	if err := cometApp.updateConsensusParams(req.ConsensusParams); err != nil {
+		fmt.Printf("Error processing InitChain: %v\n", err)
+		return nil, err
+	}
+
+	// Genesis writes are staged and persisted together with the first block.
+	if err := cometApp.state.InitValidators(req.Validators); err != nil {
+		fmt.Printf("Error processing InitChain: %v\n", err)
+		return nil, err
+	}
+	if err := cometApp.state.InitGenesis(req.AppStateBytes); err != nil {
+		fmt.Printf("Error processing InitChain: %v\n", err)
+		return nil, err
+	}
+
+	appHash, err := cometApp.state.Hash()
+	if err != nil {
+		return nil, err
+	}
+	cometApp.lastHash = appHash
+
+	return &abci.InitChainResponse{AppHash: cometApp.lastHash}, nil
 }
 
 // ------------------------
@@ -41,23 +146,52 @@ func (cometApp *CometApp) InitChain(ctx context.Context, req *abci.InitChainRequ
 
 func (cometApp *CometApp) CheckTx(ctx context.Context, req *abci.CheckTxRequest) (*abci.CheckTxResponse, error) {
 	// This is where the app is hooked into the CheckTx process.
-	_, err := app.ProcessTX(req)
+	res, err := app.ProcessTX(cometApp.decoder, req)
 	if err != nil {
 		fmt.Printf("Error processing CheckTx: %v\n", err)
 		return nil, err
 	}
 
-	return &abci.CheckTxResponse{}, nil
+	// Rechecks see txs already counted when they entered the mempool.
+	if req.Type != abci.CHECK_TX_TYPE_RECHECK {
+		cometApp.metrics.TxsChecked.Inc()
+		if !res.IsOK() {
+			cometApp.metrics.TxsRejected.Inc()
+		}
+	}
+	return res, nil
 }
 
 // PrepareProposal: setup or filter transactions for the block proposal.
+// Txs keep their mempool order; invalid txs, repeats of an earlier tx and
+// txs that no longer fit are skipped, so smaller txs behind them can still be included.
 func (cometApp *CometApp) PrepareProposal(ctx context.Context, req *abci.PrepareProposalRequest) (*abci.PrepareProposalResponse, error) {
+	// Never propose more than ProcessProposal would accept, nor more than CometBFT allows.
+	// A node may also choose to propose smaller blocks.
+	maxTxBytes := min(cometApp.MaxTxBytes(), req.MaxTxBytes)
+	if limit := cometApp.config.MaxTxBytes; limit > 0 {
+		maxTxBytes = min(maxTxBytes, limit)
+	}
+
 	var out [][]byte
 	var sz int64
+	seen := make(map[types.TxKey]struct{}, len(req.Txs))
 	for _, tx := range req.Txs {
-		if sz+int64(len(tx)) > req.MaxTxBytes {
-			break
+		if sz+int64(len(tx)) > maxTxBytes {
+			continue
+		}
+		key := types.Tx(tx).Key()
+		if _, ok := seen[key]; ok {
+			continue
 		}
+		res, err := app.ProcessTX(cometApp.decoder, &abci.CheckTxRequest{Tx: tx})
+		if err != nil {
+			return nil, err
+		}
+		if !res.IsOK() {
+			continue
+		}
+		seen[key] = struct{}{}
 		out = append(out, tx)
 		sz += int64(len(tx))
 	}
@@ -71,8 +205,7 @@ func (cometApp *CometApp) ProcessProposal(ctx context.Context, req *abci.Process
 		sz += int64(len(tx))
 	}
 
-	maxTxsBytes := int64(1000000)
-	if maxTxsBytes > 0 && sz > maxTxsBytes {
+	if sz > cometApp.MaxTxBytes() {
 		return &abci.ProcessProposalResponse{Status: abci.PROCESS_PROPOSAL_STATUS_REJECT}, nil
 	}
 	return &abci.ProcessProposalResponse{Status: abci.PROCESS_PROPOSAL_STATUS_ACCEPT}, nil
@@ -84,76 +217,150 @@ func (cometApp *CometApp) ProcessProposal(ctx context.Context, req *abci.Process
 
 // Commit: this is called at the end of a block, after all transactions have been processed.
 func (cometApp *CometApp) Commit(ctx context.Context, req *abci.CommitRequest) (*abci.CommitResponse, error) {
+	height, appHash := cometApp.pendingHeight, cometApp.pendingHash
+	if height == 0 {
+		return nil, errors.New("commit called before FinalizeBlock")
+	}
+
+	// The block's metadata is written in the same batch as its state changes,
+	// so the two can never disagree on disk.
+	if err := cometApp.stageLastBlock(height, appHash); err != nil {
+		return nil, err
+	}
 
 	// This is where the app is hooked into the Commit process.
-	_, err := app.CommitData(req)
+	_, err := cometApp.state.CommitData(height)
 	if err != nil {
 		fmt.Printf("Error processing Commit: %v\n", err)
 		return nil, err
 	}
+	cometApp.lastHeight, cometApp.lastHash = height, appHash
+	cometApp.pendingHeight, cometApp.pendingHash = 0, nil
+	cometApp.updateStateMetrics()
 
-	b := cometApp.db.NewBatch()
-	defer b.Close()
+	interval := cometApp.config.SnapshotInterval
+	if interval > 0 && uint64(height)%interval == 0 {
+		cometApp.createSnapshot(height)
+	}
 
-	//commit data to db
-	err = b.Set([]byte("lastAppHash"), cometApp.lastHash)
-	if err != nil {
-		return nil, err
+	return &abci.CommitResponse{}, nil
+}
+
+// createSnapshot writes a snapshot of the state at height in the background,
+// so that it doesn't hold up consensus. If the previous snapshot is still
+// being written, this one is skipped. Snapshot failures are not fatal: the
+// block is already committed.
+func (cometApp *CometApp) createSnapshot(height int64) {
+	if !cometApp.snapshotting.TryLock() {
+		fmt.Printf("Skipping snapshot at height %d: previous snapshot still in progress\n", height)
+		return
 	}
-	err = b.Set([]byte("lastHeight"), []byte{byte(cometApp.lastHeight)})
+	// Open the view now, before the next block is committed.
+	view, err := cometApp.state.ViewAt(height)
 	if err != nil {
-		return nil, err
+		cometApp.snapshotting.Unlock()
+		fmt.Printf("Error creating snapshot at height %d: %v\n", height, err)
+		return
 	}
-	if err := b.WriteSync(); err != nil {
-		return nil, err
+	go func() {
+		defer cometApp.snapshotting.Unlock()
+		defer view.Close()
+		if _, err := cometApp.snapshots.Create(view, uint64(height)); err != nil {
+			fmt.Printf("Error creating snapshot at height %d: %v\n", height, err)
+		}
+	}()
+}
+
+// WaitSnapshots blocks until the snapshot being written, if any, is done.
+func (cometApp *CometApp) WaitSnapshots() {
+	cometApp.snapshotting.Lock()
+	defer cometApp.snapshotting.Unlock()
+}
+
+// stageLastBlock stages the block's height and app hash, to be persisted
+// with the next state commit.
+func (cometApp *CometApp) stageLastBlock(height int64, appHash []byte) error {
+	err := cometApp.state.SetMetadata([]byte("lastAppHash"), appHash)
+	if err != nil {
+		return err
 	}
+	return cometApp.state.SetMetadata([]byte("lastHeight"), binary.BigEndian.AppendUint64(nil, uint64(height)))
+}
 
-	return &abci.CommitResponse{}, nil
+// updateStateMetrics refreshes the metrics that describe the committed state.
+func (cometApp *CometApp) updateStateMetrics() {
+	n, err := cometApp.state.Count()
+	if err != nil {
+		fmt.Printf("Error counting state keys: %v\n", err)
+		return
+	}
+	cometApp.metrics.StateKeys.Set(float64(n))
 }
 
 // FinalizeBlock: this is called at the end of a block, after all transactions have been processed but before the block is finalized and committed.
 func (cometApp *CometApp) FinalizeBlock(ctx context.Context, req *abci.FinalizeBlockRequest) (*abci.FinalizeBlockResponse, error) {
-	cometApp.lastHeight = req.Height
+	start := time.Now()
+	defer func() {
+		cometApp.metrics.FinalizeBlockDuration.Observe(time.Since(start).Seconds())
+	}()
 
-	h := sha256.New()
-	var hb [8]byte
-	binary.BigEndian.PutUint64(hb[:], uint64(req.Height))
-	h.Write(hb[:])
-	for _, tx := range req.Txs {
-		h.Write(tx)
-	}
-	cometApp.lastHash = h.Sum(nil)
+	cometApp.pendingHeight = req.Height
 
+	// The gas limit is a consensus param, so every validator meters the same way.
+	blockGas := app.NewGasMeter(max(cometApp.consensusMaxGas, 0))
 	results := make([]*abci.ExecTxResult, len(req.Txs))
+	// CometBFT rejects more than one update per validator in a block, so a
+	// later update replaces an earlier one in place.
+	validatorUpdates := []abci.ValidatorUpdate{}
+	validatorIndex := make(map[string]int)
 	for i, tx := range req.Txs {
-		results[i] = &abci.ExecTxResult{
-			Code:      0,   // 0 means OK
-			Data:      nil, // optional return data
-			Log:       "",  // optional log string
-			Info:      "",  // optional info string
-			GasWanted: 0,   // optional
-			GasUsed:   0,   // optional
-			Events:    nil, // optional ABCI events
+		// This is where the app is hooked into the FinalizeBlock process.
+		var update *abci.ValidatorUpdate
+		results[i], update = cometApp.state.DeliverTX(cometApp.decoder, tx, blockGas)
+		if update != nil {
+			if j, ok := validatorIndex[string(update.PubKeyBytes)]; ok {
+				validatorUpdates[j] = *update
+			} else {
+				validatorIndex[string(update.PubKeyBytes)] = len(validatorUpdates)
+				validatorUpdates = append(validatorUpdates, *update)
+			}
 		}
-		// You could decode/process tx here and fill fields appropriately
-		_ = tx // placeholder so tx is "used"
+		if results[i].IsOK() {
+			cometApp.metrics.TxsApplied.Inc()
+		} else {
+			cometApp.metrics.TxsRejected.Inc()
+		}
+	}
+
+	// The AppHash commits to the resulting state, not to the txs that produced it.
+	appHash, err := cometApp.state.Hash()
+	if err != nil {
+		fmt.Printf("Error hashing state: %v\n", err)
+		return nil, err
 	}
+	cometApp.pendingHash = appHash
 
 	return &abci.FinalizeBlockResponse{
-		Events:                []abci.Event{},
-		ValidatorUpdates:      []abci.ValidatorUpdate{},
-		ConsensusParamUpdates: &v1.ConsensusParams{},
-		AppHash:               cometApp.lastHash,
-		TxResults:             results,
+		Events:           []abci.Event{app.BlockEvent(results)},
+		ValidatorUpdates: validatorUpdates,
+		AppHash:          cometApp.pendingHash,
+		TxResults:        results,
 	}, nil
 }
 
+// ExtendVote attests to the validator's state by extending its precommit with
+// the AppHash of the last committed block.
 func (cometApp *CometApp) ExtendVote(ctx context.Context, req *abci.ExtendVoteRequest) (*abci.ExtendVoteResponse, error) {
-	return &abci.ExtendVoteResponse{}, nil
+	return &abci.ExtendVoteResponse{VoteExtension: cometApp.lastHash}, nil
 }
 
+// VerifyVoteExtension only checks that the extension is shaped like a state
+// digest; validators whose state diverged still have their votes accepted.
 func (cometApp *CometApp) VerifyVoteExtension(ctx context.Context, req *abci.VerifyVoteExtensionRequest) (*abci.VerifyVoteExtensionResponse, error) {
-	return &abci.VerifyVoteExtensionResponse{}, nil
+	if len(req.VoteExtension) != tmhash.Size {
+		return &abci.VerifyVoteExtensionResponse{Status: abci.VERIFY_VOTE_EXTENSION_STATUS_REJECT}, nil
+	}
+	return &abci.VerifyVoteExtensionResponse{Status: abci.VERIFY_VOTE_EXTENSION_STATUS_ACCEPT}, nil
 }
 
 // ------------------------
@@ -174,29 +381,123 @@ func (cometApp *CometApp) Info(ctx context.Context, req *abci.InfoRequest) (*abc
 
 // Query: this is called to query the application for data based on a path and data at /abci_query.
 func (cometApp *CometApp) Query(ctx context.Context, req *abci.QueryRequest) (*abci.QueryResponse, error) {
+	fmt.Printf("Query: path=%s, data=%X, height=%d\n", req.Path, req.Data, req.Height)
+
+	// Height 0 means the latest committed height.
+	height := req.Height
+	if height == 0 {
+		height = cometApp.lastHeight
+	}
+	if height < 0 || height > cometApp.lastHeight {
+		return &abci.QueryResponse{
+			Code:   app.CodeTypeHeightNotExists,
+			Log:    fmt.Sprintf("height %d is not committed; latest height is %d", height, cometApp.lastHeight),
+			Height: height,
+		}, nil
+	}
+
 	// This is where the app is hooked into the Query process.
-	_, err := app.QueryData(req)
+	res, err := cometApp.state.QueryData(req, height)
 	if err != nil {
 		fmt.Printf("Error processing Query: %v\n", err)
 		return nil, err
 	}
-
-	fmt.Printf("Query: path=%s, data=%X\n", req.Path, req.Data)
-	return &abci.QueryResponse{}, nil
+	return res, nil
 }
 
+// ------------------------
+// State sync
+// ------------------------
+
+// ListSnapshots: advertise the locally stored snapshots to peers that are state syncing.
 func (cometApp *CometApp) ListSnapshots(ctx context.Context, req *abci.ListSnapshotsRequest) (*abci.ListSnapshotsResponse, error) {
-	return &abci.ListSnapshotsResponse{}, nil
+	snapshots, err := cometApp.snapshots.List()
+	if err != nil {
+		fmt.Printf("Error listing snapshots: %v\n", err)
+		return nil, err
+	}
+	return &abci.ListSnapshotsResponse{Snapshots: snapshots}, nil
 }
 
+// OfferSnapshot: a peer offers a snapshot to restore from; accept it if we can decode it.
 func (cometApp *CometApp) OfferSnapshot(ctx context.Context, req *abci.OfferSnapshotRequest) (*abci.OfferSnapshotResponse, error) {
-	return &abci.OfferSnapshotResponse{}, nil
+	if req.Snapshot == nil {
+		return &abci.OfferSnapshotResponse{Result: abci.OFFER_SNAPSHOT_RESULT_REJECT}, nil
+	}
+	if req.Snapshot.Format != app.SnapshotFormat {
+		return &abci.OfferSnapshotResponse{Result: abci.OFFER_SNAPSHOT_RESULT_REJECT_FORMAT}, nil
+	}
+
+	restore, err := app.NewSnapshotRestore(req.Snapshot, req.AppHash)
+	if err != nil {
+		fmt.Printf("Rejecting snapshot at height %d: %v\n", req.Snapshot.Height, err)
+		return &abci.OfferSnapshotResponse{Result: abci.OFFER_SNAPSHOT_RESULT_REJECT}, nil
+	}
+	// Drop anything staged by an earlier, abandoned restore.
+	cometApp.state.Discard()
+	cometApp.restore = restore
+	return &abci.OfferSnapshotResponse{Result: abci.OFFER_SNAPSHOT_RESULT_ACCEPT}, nil
 }
 
+// LoadSnapshotChunk: serve a chunk of a local snapshot to a peer.
 func (cometApp *CometApp) LoadSnapshotChunk(ctx context.Context, req *abci.LoadSnapshotChunkRequest) (*abci.LoadSnapshotChunkResponse, error) {
-	return &abci.LoadSnapshotChunkResponse{}, nil
+	chunk, err := cometApp.snapshots.LoadChunk(req.Height, req.Format, req.Chunk)
+	if err != nil {
+		fmt.Printf("Error loading snapshot chunk: %v\n", err)
+		return nil, err
+	}
+	return &abci.LoadSnapshotChunkResponse{Chunk: chunk}, nil
 }
 
+// ApplySnapshotChunk: verify a chunk and stage its entries; once all have arrived, check and commit the restored state.
 func (cometApp *CometApp) ApplySnapshotChunk(ctx context.Context, req *abci.ApplySnapshotChunkRequest) (*abci.ApplySnapshotChunkResponse, error) {
-	return &abci.ApplySnapshotChunkResponse{}, nil
+	restore := cometApp.restore
+	if restore == nil {
+		return &abci.ApplySnapshotChunkResponse{Result: abci.APPLY_SNAPSHOT_CHUNK_RESULT_ABORT}, nil
+	}
+
+	done, err := restore.AddChunk(cometApp.state, req.Index, req.Chunk)
+	if errors.Is(err, app.ErrChunkHashMismatch) {
+		// Ask for the chunk again, from someone else.
+		return &abci.ApplySnapshotChunkResponse{
+			Result:        abci.APPLY_SNAPSHOT_CHUNK_RESULT_RETRY,
+			RefetchChunks: []uint32{req.Index},
+			RejectSenders: []string{req.Sender},
+		}, nil
+	}
+	if err != nil {
+		cometApp.restore = nil
+		cometApp.state.Discard()
+		fmt.Printf("Error applying snapshot chunk %d: %v\n", req.Index, err)
+		// CometBFT offers the snapshot again, starting a new restore.
+		return &abci.ApplySnapshotChunkResponse{Result: abci.APPLY_SNAPSHOT_CHUNK_RESULT_RETRY_SNAPSHOT}, nil
+	}
+	if !done {
+		return &abci.ApplySnapshotChunkResponse{Result: abci.APPLY_SNAPSHOT_CHUNK_RESULT_ACCEPT}, nil
+	}
+
+	cometApp.restore = nil
+	appHash, err := cometApp.state.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(appHash, restore.AppHash) {
+		cometApp.state.Discard()
+		fmt.Printf("Restored state hash %X does not match app hash %X\n", appHash, restore.AppHash)
+		return &abci.ApplySnapshotChunkResponse{Result: abci.APPLY_SNAPSHOT_CHUNK_RESULT_REJECT_SNAPSHOT}, nil
+	}
+	height := int64(restore.Snapshot.Height)
+	if err := cometApp.stageLastBlock(height, restore.AppHash); err != nil {
+		return nil, err
+	}
+	if err := cometApp.state.Commit(height); err != nil {
+		return nil, err
+	}
+	cometApp.lastHeight, cometApp.lastHash = height, restore.AppHash
+	// The node never saw InitChain, so it learns the block limits from the snapshot.
+	if err := cometApp.loadBlockParams(); err != nil {
+		return nil, err
+	}
+	cometApp.updateStateMetrics()
+	return &abci.ApplySnapshotChunkResponse{Result: abci.APPLY_SNAPSHOT_CHUNK_RESULT_ACCEPT}, nil
 }
@@ -0,0 +1,538 @@
+package comet
+
+import (
+	"bytes"
+	"cometbft-baseapp/app"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+	v1 "github.com/cometbft/cometbft/api/cometbft/types/v1"
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	"github.com/cometbft/cometbft/types"
+)
+
+// newTestApp returns an app over an in-memory database.
+func newTestApp(t *testing.T) *CometApp {
+	t.Helper()
+	config := DefaultAppConfig()
+	config.SnapshotDir = t.TempDir()
+	cometApp, err := NewCometApp(dbm.NewMemDB(), config, NewMetrics("test"), app.KVTxDecoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cometApp
+}
+
+// testValidator returns a genesis validator with a deterministic key.
+func testValidator(seed byte, power int64) abci.ValidatorUpdate {
+	priv := ed25519.GenPrivKeyFromSecret([]byte{seed})
+	return abci.NewValidatorUpdate(priv.PubKey(), power)
+}
+
+// commitBlock runs FinalizeBlock and Commit for one block.
+func commitBlock(t *testing.T, cometApp *CometApp, height int64, txs ...string) *abci.FinalizeBlockResponse {
+	t.Helper()
+	req := &abci.FinalizeBlockRequest{Height: height}
+	for _, tx := range txs {
+		req.Txs = append(req.Txs, []byte(tx))
+	}
+	ctx := context.Background()
+	res, err := cometApp.FinalizeBlock(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cometApp.Commit(ctx, &abci.CommitRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestInitChainAppHash(t *testing.T) {
+	cometApp := newTestApp(t)
+	ctx := context.Background()
+	res, err := cometApp.InitChain(ctx, &abci.InitChainRequest{
+		Validators:    []abci.ValidatorUpdate{testValidator(1, 10)},
+		AppStateBytes: []byte(`{"name": "satoshi", "greeting": "gm"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty first block leaves the genesis state, and so its hash, unchanged.
+	commitBlock(t, cometApp, 1)
+	info, err := cometApp.Info(ctx, &abci.InfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(info.LastBlockAppHash, res.AppHash) {
+		t.Errorf("Info app hash = %X, want InitChain's %X", info.LastBlockAppHash, res.AppHash)
+	}
+	query, err := cometApp.Query(ctx, &abci.QueryRequest{Path: app.QueryPathStore, Data: []byte("greeting")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(query.Value) != "gm" {
+		t.Errorf("greeting = %q, want gm", query.Value)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	source := newTestApp(t)
+	source.config.SnapshotInterval = 2
+	ctx := context.Background()
+
+	// Large enough to span several chunks, with entries cut at chunk boundaries.
+	genesis := map[string]string{}
+	for _, key := range []string{"a", "b", "c"} {
+		genesis[key] = strings.Repeat(key, 700*1024)
+	}
+	appState, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.InitChain(ctx, &abci.InitChainRequest{
+		Validators:      []abci.ValidatorUpdate{testValidator(1, 10)},
+		AppStateBytes:   appState,
+		ConsensusParams: &v1.ConsensusParams{Block: &v1.BlockParams{MaxBytes: -1, MaxGas: 1_000_000}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	commitBlock(t, source, 1, "name=satoshi")
+	appHash := commitBlock(t, source, 2, "greeting=gm").AppHash
+	source.WaitSnapshots()
+	// Not part of the snapshot at height 2.
+	commitBlock(t, source, 3, "name=nakamoto")
+
+	list, err := source.ListSnapshots(ctx, &abci.ListSnapshotsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Snapshots) != 1 || list.Snapshots[0].Height != 2 {
+		t.Fatalf("snapshots = %v, want one at height 2", list.Snapshots)
+	}
+	snapshot := list.Snapshots[0]
+	if snapshot.Chunks < 2 {
+		t.Fatalf("snapshot has %d chunks, want several", snapshot.Chunks)
+	}
+
+	target := newTestApp(t)
+	offer, err := target.OfferSnapshot(ctx, &abci.OfferSnapshotRequest{Snapshot: snapshot, AppHash: appHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.Result != abci.OFFER_SNAPSHOT_RESULT_ACCEPT {
+		t.Fatalf("OfferSnapshot = %v, want ACCEPT", offer.Result)
+	}
+
+	for i := uint32(0); i < snapshot.Chunks; i++ {
+		chunk, err := source.LoadSnapshotChunk(ctx, &abci.LoadSnapshotChunkRequest{Height: 2, Format: snapshot.Format, Chunk: i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			// A corrupted chunk is refetched from another peer.
+			bad := append([]byte{}, chunk.Chunk...)
+			bad[0] ^= 0xFF
+			res, err := target.ApplySnapshotChunk(ctx, &abci.ApplySnapshotChunkRequest{Index: i, Chunk: bad, Sender: "peer"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Result != abci.APPLY_SNAPSHOT_CHUNK_RESULT_RETRY || len(res.RefetchChunks) != 1 || res.RefetchChunks[0] != i ||
+				len(res.RejectSenders) != 1 || res.RejectSenders[0] != "peer" {
+				t.Fatalf("bad chunk: %v, want RETRY refetching chunk %d from another sender", res, i)
+			}
+		}
+		res, err := target.ApplySnapshotChunk(ctx, &abci.ApplySnapshotChunkRequest{Index: i, Chunk: chunk.Chunk})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Result != abci.APPLY_SNAPSHOT_CHUNK_RESULT_ACCEPT {
+			t.Fatalf("chunk %d: %v, want ACCEPT", i, res.Result)
+		}
+	}
+
+	info, err := target.Info(ctx, &abci.InfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.LastBlockHeight != 2 || !bytes.Equal(info.LastBlockAppHash, appHash) {
+		t.Errorf("restored Info = height %d, hash %X", info.LastBlockHeight, info.LastBlockAppHash)
+	}
+	// The restored node never sees InitChain, but must meter gas like the others.
+	if target.consensusMaxGas != source.consensusMaxGas {
+		t.Errorf("restored max gas = %d, want %d", target.consensusMaxGas, source.consensusMaxGas)
+	}
+	// and accept the same proposals: max_bytes = -1 allows blocks above the
+	// 4 MB default.
+	if target.MaxTxBytes() != types.MaxBlockSizeBytes {
+		t.Errorf("restored max tx bytes = %d, want %d", target.MaxTxBytes(), types.MaxBlockSizeBytes)
+	}
+	var txs [][]byte
+	for i := 0; i < 80; i++ {
+		txs = append(txs, []byte(strconv.Itoa(i)+"="+strings.Repeat("v", 64*1000)))
+	}
+	for _, cometApp := range []*CometApp{source, target} {
+		res, err := cometApp.ProcessProposal(ctx, &abci.ProcessProposalRequest{Txs: txs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != abci.PROCESS_PROPOSAL_STATUS_ACCEPT {
+			t.Errorf("5 MB proposal: %v, want ACCEPT", res.Status)
+		}
+	}
+	want := map[string]string{"name": "satoshi", "greeting": "gm"}
+	for key, value := range genesis {
+		want[key] = value
+	}
+	for key, value := range want {
+		res, err := target.Query(ctx, &abci.QueryRequest{Path: app.QueryPathStore, Data: []byte(key)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res.Value) != value {
+			t.Errorf("restored %s has %d bytes, want %d", key, len(res.Value), len(value))
+		}
+	}
+}
+
+func TestBlockGasFromConsensusParams(t *testing.T) {
+	cometApp := newTestApp(t)
+	ctx := context.Background()
+	if _, err := cometApp.InitChain(ctx, &abci.InitChainRequest{
+		Validators:      []abci.ValidatorUpdate{testValidator(1, 10)},
+		ConsensusParams: &v1.ConsensusParams{Block: &v1.BlockParams{MaxBytes: 1 << 20, MaxGas: 50}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	res := commitBlock(t, cometApp, 1, "a=1", "big=value", "b=2")
+	for i, want := range []uint32{app.CodeTypeOK, app.CodeTypeBlockGasExceeded, app.CodeTypeOK} {
+		if res.TxResults[i].Code != want {
+			t.Errorf("tx %d: code %d, want %d", i, res.TxResults[i].Code, want)
+		}
+	}
+
+	// The limit is restored from the database after a restart.
+	restarted, err := NewCometApp(cometApp.db, cometApp.config, NewMetrics("test"), app.KVTxDecoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.consensusMaxGas != 50 {
+		t.Errorf("max gas after restart = %d, want 50", restarted.consensusMaxGas)
+	}
+}
+
+func TestFinalizeBlockEvents(t *testing.T) {
+	cometApp := newTestApp(t)
+	res := commitBlock(t, cometApp, 1, "name=satoshi", "invalid", "greeting=gm")
+
+	for i, want := range map[int][2]string{0: {"name", "satoshi"}, 2: {"greeting", "gm"}} {
+		events := res.TxResults[i].Events
+		if len(events) != 1 || events[0].Type != app.EventTypeKVStore {
+			t.Fatalf("tx %d events = %v, want one %s event", i, events, app.EventTypeKVStore)
+		}
+		attrs := map[string]abci.EventAttribute{}
+		for _, attr := range events[0].Attributes {
+			attrs[attr.Key] = attr
+		}
+		if attr := attrs["key"]; attr.Value != want[0] || !attr.Index {
+			t.Errorf("tx %d key attribute = %+v, want indexed %q", i, attr, want[0])
+		}
+		if attr := attrs["value"]; attr.Value != want[1] || !attr.Index {
+			t.Errorf("tx %d value attribute = %+v, want indexed %q", i, attr, want[1])
+		}
+	}
+	if events := res.TxResults[1].Events; len(events) != 0 {
+		t.Errorf("rejected tx has events %v, want none", events)
+	}
+
+	if len(res.Events) != 1 || res.Events[0].Type != app.EventTypeBlock {
+		t.Fatalf("block events = %v, want one %s event", res.Events, app.EventTypeBlock)
+	}
+	if attrs := res.Events[0].Attributes; len(attrs) != 1 || attrs[0].Key != "applied_txs" || attrs[0].Value != "2" {
+		t.Errorf("block event attributes = %v, want applied_txs=2", attrs)
+	}
+}
+
+func TestProcessProposalMaxTxBytes(t *testing.T) {
+	cometApp := newTestApp(t)
+	ctx := context.Background()
+	if _, err := cometApp.InitChain(ctx, &abci.InitChainRequest{
+		Validators:      []abci.ValidatorUpdate{testValidator(1, 10)},
+		ConsensusParams: &v1.ConsensusParams{Block: &v1.BlockParams{MaxBytes: 100, MaxGas: -1}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The node's own proposal cap must not affect which proposals it accepts.
+	cometApp.config.MaxTxBytes = 10
+
+	tests := []struct {
+		size int
+		want abci.ProcessProposalStatus
+	}{
+		{100, abci.PROCESS_PROPOSAL_STATUS_ACCEPT},
+		{101, abci.PROCESS_PROPOSAL_STATUS_REJECT},
+	}
+	for _, tt := range tests {
+		txs := [][]byte{[]byte("k=" + strings.Repeat("v", 48)), []byte("k2=" + strings.Repeat("v", tt.size-50-3))}
+		res, err := cometApp.ProcessProposal(ctx, &abci.ProcessProposalRequest{Txs: txs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != tt.want {
+			t.Errorf("%d tx bytes: %v, want %v", tt.size, res.Status, tt.want)
+		}
+	}
+}
+
+func TestPrepareProposal(t *testing.T) {
+	big := "big=" + strings.Repeat("v", 40)
+	tests := []struct {
+		name       string
+		txs        []string
+		maxTxBytes int64
+		want       []string
+	}{
+		{"duplicate", []string{"name=satoshi", "greeting=gm", "name=satoshi"}, 1000, []string{"name=satoshi", "greeting=gm"}},
+		{"invalid", []string{"name=satoshi", "invalid", "=gm", "greeting=gm"}, 1000, []string{"name=satoshi", "greeting=gm"}},
+		{"too large", []string{"a=1", big, "b=2", "c=3"}, 20, []string{"a=1", "b=2", "c=3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &abci.PrepareProposalRequest{MaxTxBytes: tt.maxTxBytes}
+			for _, tx := range tt.txs {
+				req.Txs = append(req.Txs, []byte(tx))
+			}
+			res, err := newTestApp(t).PrepareProposal(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tx := range res.Txs {
+				got = append(got, string(tx))
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("proposed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorTx(t *testing.T) {
+	cometApp := newTestApp(t)
+	ctx := context.Background()
+	val1, val2 := testValidator(1, 10), testValidator(2, 10)
+	if _, err := cometApp.InitChain(ctx, &abci.InitChainRequest{Validators: []abci.ValidatorUpdate{val1, val2}}); err != nil {
+		t.Fatal(err)
+	}
+	valTx := func(val abci.ValidatorUpdate, power int64) string {
+		return "val:" + base64.StdEncoding.EncodeToString(val.PubKeyBytes) + ":" + strconv.FormatInt(power, 10)
+	}
+	val3 := testValidator(3, 5)
+
+	tests := []struct {
+		name string
+		tx   string
+		code uint32
+		want *abci.ValidatorUpdate
+	}{
+		{"add", valTx(val3, 5), app.CodeTypeOK, &val3},
+		{"remove", valTx(val2, 0), app.CodeTypeOK, &abci.ValidatorUpdate{PubKeyType: val2.PubKeyType, PubKeyBytes: val2.PubKeyBytes, Power: 0}},
+		{"malformed pubkey", "val:" + base64.StdEncoding.EncodeToString([]byte("short")) + ":5", app.CodeTypeInvalidValidatorTx, nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := commitBlock(t, cometApp, int64(i+1), tt.tx)
+			if code := res.TxResults[0].Code; code != tt.code {
+				t.Fatalf("code %d, want %d: %s", code, tt.code, res.TxResults[0].Log)
+			}
+			if tt.want == nil {
+				if len(res.ValidatorUpdates) != 0 {
+					t.Errorf("validator updates %v, want none", res.ValidatorUpdates)
+				}
+				return
+			}
+			if len(res.ValidatorUpdates) != 1 {
+				t.Fatalf("validator updates %v, want one", res.ValidatorUpdates)
+			}
+			got := res.ValidatorUpdates[0]
+			if !bytes.Equal(got.PubKeyBytes, tt.want.PubKeyBytes) || got.PubKeyType != tt.want.PubKeyType || got.Power != tt.want.Power {
+				t.Errorf("validator update %v, want %v", got, *tt.want)
+			}
+		})
+	}
+}
+
+// failingDB is a database whose batches fail to write once fail is set.
+type failingDB struct {
+	dbm.DB
+	fail bool
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (db *failingDB) NewBatch() dbm.Batch {
+	return &failingBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+type failingBatch struct {
+	dbm.Batch
+	db *failingDB
+}
+
+func (b *failingBatch) Write() error {
+	if b.db.fail {
+		return errWriteFailed
+	}
+	return b.Batch.Write()
+}
+
+func (b *failingBatch) WriteSync() error {
+	if b.db.fail {
+		return errWriteFailed
+	}
+	return b.Batch.WriteSync()
+}
+
+func TestCommitWriteFailure(t *testing.T) {
+	db := &failingDB{DB: dbm.NewMemDB()}
+	config := DefaultAppConfig()
+	config.SnapshotDir = t.TempDir()
+	cometApp, err := NewCometApp(db, config, NewMetrics("test"), app.KVTxDecoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	commitBlock(t, cometApp, 1, "name=satoshi")
+	lastHash := cometApp.lastHash
+
+	if _, err := cometApp.FinalizeBlock(ctx, &abci.FinalizeBlockRequest{Height: 2, Txs: [][]byte{[]byte("name=nakamoto")}}); err != nil {
+		t.Fatal(err)
+	}
+	db.fail = true
+	if _, err := cometApp.Commit(ctx, &abci.CommitRequest{}); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("Commit = %v, want %v", err, errWriteFailed)
+	}
+
+	// Nothing of block 2 is recorded, in memory or on disk.
+	if cometApp.lastHeight != 1 || !bytes.Equal(cometApp.lastHash, lastHash) {
+		t.Errorf("last block = %d %X, want 1 %X", cometApp.lastHeight, cometApp.lastHash, lastHash)
+	}
+	if cometApp.pendingHeight != 2 {
+		t.Errorf("pending height = %d, want 2", cometApp.pendingHeight)
+	}
+	hash, height := GetLastBlockHashAndHeight(db)
+	if height != 1 || !bytes.Equal(hash, lastHash) {
+		t.Errorf("persisted last block = %d %X, want 1 %X", height, hash, lastHash)
+	}
+	if value, err := db.Get([]byte("kv:name")); err != nil || string(value) != "satoshi" {
+		t.Errorf("persisted name = %q, %v; want satoshi", value, err)
+	}
+	it, err := db.Iterator([]byte("v:"), []byte("v;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	n := 0
+	for ; it.Valid(); it.Next() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("%d persisted versions, want 1", n)
+	}
+}
+
+func TestVoteExtensions(t *testing.T) {
+	cometApp := newTestApp(t)
+	ctx := context.Background()
+	if _, err := cometApp.InitChain(ctx, &abci.InitChainRequest{
+		Validators:    []abci.ValidatorUpdate{testValidator(1, 10)},
+		AppStateBytes: []byte(`{"name": "satoshi"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	res := commitBlock(t, cometApp, 1, "greeting=gm")
+
+	ext, err := cometApp.ExtendVote(ctx, &abci.ExtendVoteRequest{Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ext.VoteExtension, res.AppHash) {
+		t.Errorf("vote extension %X, want the last AppHash %X", ext.VoteExtension, res.AppHash)
+	}
+
+	tests := []struct {
+		name      string
+		extension []byte
+		want      abci.VerifyVoteExtensionStatus
+	}{
+		{"app hash", ext.VoteExtension, abci.VERIFY_VOTE_EXTENSION_STATUS_ACCEPT},
+		{"other 32 bytes", bytes.Repeat([]byte{1}, 32), abci.VERIFY_VOTE_EXTENSION_STATUS_ACCEPT},
+		{"empty", nil, abci.VERIFY_VOTE_EXTENSION_STATUS_REJECT},
+		{"too short", bytes.Repeat([]byte{1}, 31), abci.VERIFY_VOTE_EXTENSION_STATUS_REJECT},
+		{"too long", bytes.Repeat([]byte{1}, 33), abci.VERIFY_VOTE_EXTENSION_STATUS_REJECT},
+	}
+	for _, tt := range tests {
+		res, err := cometApp.VerifyVoteExtension(ctx, &abci.VerifyVoteExtensionRequest{Height: 2, VoteExtension: tt.extension})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, res.Status, tt.want)
+		}
+	}
+}
+
+// TestValidatorUpdatesApply checks that CometBFT can apply the validator
+// updates of every block, whatever validator txs it holds.
+func TestValidatorUpdatesApply(t *testing.T) {
+	cometApp := newTestApp(t)
+	ctx := context.Background()
+	genesis := []abci.ValidatorUpdate{testValidator(1, 10), testValidator(2, 10)}
+	if _, err := cometApp.InitChain(ctx, &abci.InitChainRequest{Validators: genesis}); err != nil {
+		t.Fatal(err)
+	}
+	vals, err := types.PB2TM.ValidatorUpdates(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valSet := types.NewValidatorSet(vals)
+
+	valTx := func(val abci.ValidatorUpdate, power int64) string {
+		return "val:" + base64.StdEncoding.EncodeToString(val.PubKeyBytes) + ":" + strconv.FormatInt(power, 10)
+	}
+	newVal := testValidator(3, 0)
+	blocks := []struct {
+		name  string
+		txs   []string
+		codes []uint32
+	}{
+		{"add then remove", []string{valTx(newVal, 5), valTx(newVal, 0)}, []uint32{app.CodeTypeOK, app.CodeTypeInvalidValidatorTx}},
+		{"over the total power", []string{valTx(testValidator(4, 0), types.MaxTotalVotingPower)}, []uint32{app.CodeTypeInvalidValidatorTx}},
+		{"remove in a later block", []string{valTx(newVal, 0)}, []uint32{app.CodeTypeOK}},
+	}
+	for i, block := range blocks {
+		res := commitBlock(t, cometApp, int64(i+1), block.txs...)
+		for j, want := range block.codes {
+			if code := res.TxResults[j].Code; code != want {
+				t.Errorf("%s: tx %d code %d, want %d: %s", block.name, j, code, want, res.TxResults[j].Log)
+			}
+		}
+		changes, err := types.PB2TM.ValidatorUpdates(res.ValidatorUpdates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := valSet.UpdateWithChangeSet(changes); err != nil {
+			t.Fatalf("%s: CometBFT can't apply the validator updates: %v", block.name, err)
+		}
+	}
+	if valSet.Size() != 2 {
+		t.Errorf("%d validators, want 2", valSet.Size())
+	}
+}
@@ -1,11 +1,88 @@
 package comet
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
 	"time"
 
 	cfg "github.com/cometbft/cometbft/config"
+	"github.com/pelletier/go-toml/v2"
 )
 
+// AppConfigFile is the name of the app config file in the config directory.
+const AppConfigFile = "app.toml"
+
+// AppConfig holds the application's own settings, as opposed to CometBFT's.
+type AppConfig struct {
+	// DBBackend is the cometbft-db backend of the application database.
+	DBBackend string `toml:"db_backend"`
+	// SnapshotInterval is the number of blocks between state-sync snapshots.
+	// Zero disables snapshotting.
+	SnapshotInterval uint64 `toml:"snapshot_interval"`
+	// SnapshotKeepRecent is the number of snapshots kept on disk. Zero keeps all.
+	SnapshotKeepRecent int `toml:"snapshot_keep_recent"`
+	// SnapshotDir is where snapshots are stored, relative to the home directory
+	// unless absolute.
+	SnapshotDir string `toml:"snapshot_dir"`
+	// MaxTxBytes caps the total tx bytes in blocks this node proposes, below
+	// the consensus params' block size. Zero proposes up to the block size.
+	// It doesn't affect which proposals the node accepts.
+	MaxTxBytes int64 `toml:"max_tx_bytes"`
+	// StateKeepRecent is the number of recent heights kept queryable by
+	// historical queries. Zero keeps every height, so the state versions
+	// grow without bound.
+	StateKeepRecent int64 `toml:"state_keep_recent"`
+}
+
+func DefaultAppConfig() *AppConfig {
+	return &AppConfig{
+		DBBackend:          "pebbledb",
+		SnapshotInterval:   100,
+		SnapshotKeepRecent: 2,
+		SnapshotDir:        "snapshots",
+		StateKeepRecent:    1000,
+	}
+}
+
+// LoadAppConfig reads the app config from the TOML file at path. Settings
+// missing from the file, or the whole file if it doesn't exist, keep their
+// defaults. Unknown settings are an error, to catch typos.
+func LoadAppConfig(path string) (*AppConfig, error) {
+	config := DefaultAppConfig()
+	bz, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dec := toml.NewDecoder(bytes.NewReader(bz))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		var strict *toml.StrictMissingError
+		if errors.As(err, &strict) {
+			// The error itself doesn't say which settings are unknown.
+			return nil, fmt.Errorf("decoding %s: unknown settings:\n%s", path, strict.String())
+		}
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// WriteAppConfig writes config to path as TOML.
+func WriteAppConfig(path string, config *AppConfig) error {
+	bz, err := toml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	header := []byte("# Application settings. Settings left out keep their defaults.\n\n")
+	return os.WriteFile(path, append(header, bz...), 0o644)
+}
+
 func SetDefaultConfig(config *cfg.Config) {
 	config.DBBackend = "pebbledb"
 	config.Consensus.CreateEmptyBlocks = true
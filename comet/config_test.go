@@ -0,0 +1,67 @@
+package comet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadAppConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, AppConfigFile)
+	sample := `
+db_backend = "goleveldb"
+snapshot_interval = 0
+max_tx_bytes = 1024
+state_keep_recent = 10
+`
+	if err := os.WriteFile(path, []byte(sample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadAppConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultAppConfig()
+	want.DBBackend = "goleveldb"
+	want.SnapshotInterval = 0
+	want.MaxTxBytes = 1024
+	want.StateKeepRecent = 10
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %+v, want %+v", config, want)
+	}
+
+	// A missing file is the defaults.
+	config, err = LoadAppConfig(filepath.Join(dir, "missing.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config, DefaultAppConfig()) {
+		t.Errorf("config without a file = %+v, want the defaults", config)
+	}
+
+	if err := os.WriteFile(path, []byte("max_block_gas = 100\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAppConfig(path); err == nil || !strings.Contains(err.Error(), "max_block_gas") {
+		t.Errorf("unknown setting: err = %v, want it named", err)
+	}
+}
+
+func TestWriteAppConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AppConfigFile)
+	want := DefaultAppConfig()
+	want.MaxTxBytes = 2048
+	if err := WriteAppConfig(path, want); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadAppConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %+v, want %+v", config, want)
+	}
+}
@@ -0,0 +1,132 @@
+package comet
+
+import (
+	"cometbft-baseapp/app"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	cfg "github.com/cometbft/cometbft/config"
+	"github.com/cometbft/cometbft/crypto"
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	cmtjson "github.com/cometbft/cometbft/libs/json"
+	"github.com/cometbft/cometbft/p2p"
+	"github.com/cometbft/cometbft/privval"
+	"github.com/cometbft/cometbft/types"
+	cmttime "github.com/cometbft/cometbft/types/time"
+)
+
+// InitHome scaffolds a new node home directory: config.toml, app.toml, a node
+// key, a validator key and a single-validator genesis.json. It refuses to
+// touch an already initialized home unless force is set, in which case every
+// file is regenerated.
+func InitHome(dir string, chainID string, force bool) error {
+	config := cfg.DefaultConfig()
+	SetDefaultConfig(config)
+	config.SetRoot(dir)
+
+	files := []string{
+		configFile(config),
+		appConfigFile(config),
+		config.GenesisFile(),
+		config.NodeKeyFile(),
+		config.PrivValidatorKeyFile(),
+		config.PrivValidatorStateFile(),
+	}
+	if !force {
+		for _, file := range files {
+			if _, err := os.Stat(file); err == nil {
+				return fmt.Errorf("%s already exists; use --force to overwrite", file)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	for _, file := range files {
+		if err := os.RemoveAll(file); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range []string{config.RootDir, filepath.Join(config.RootDir, cfg.DefaultConfigDir), config.DBDir()} {
+		if err := os.MkdirAll(d, cfg.DefaultDirPerm); err != nil {
+			return err
+		}
+	}
+	if err := writeConfigFile(configFile(config), config); err != nil {
+		return err
+	}
+	if err := WriteAppConfig(appConfigFile(config), DefaultAppConfig()); err != nil {
+		return err
+	}
+
+	pv, err := privval.GenFilePV(config.PrivValidatorKeyFile(), config.PrivValidatorStateFile(), func() (crypto.PrivKey, error) {
+		return ed25519.GenPrivKey(), nil
+	})
+	if err != nil {
+		return fmt.Errorf("generating validator key: %w", err)
+	}
+	if err := writeJSONFile(config.PrivValidatorKeyFile(), pv.Key); err != nil {
+		return fmt.Errorf("saving validator key: %w", err)
+	}
+	if err := writeJSONFile(config.PrivValidatorStateFile(), pv.LastSignState); err != nil {
+		return fmt.Errorf("saving validator state: %w", err)
+	}
+
+	if _, err := p2p.LoadOrGenNodeKey(config.NodeKeyFile()); err != nil {
+		return fmt.Errorf("generating node key: %w", err)
+	}
+
+	pubKey, err := pv.GetPubKey()
+	if err != nil {
+		return fmt.Errorf("getting validator pubkey: %w", err)
+	}
+	consensusParams := types.DefaultConsensusParams()
+	consensusParams.Block.MaxGas = app.DefaultMaxBlockGas
+	// Turn on vote extensions from the first block, so ExtendVote runs.
+	consensusParams.Feature.VoteExtensionsEnableHeight = 1
+	genDoc := types.GenesisDoc{
+		ChainID:         chainID,
+		GenesisTime:     cmttime.Now(),
+		ConsensusParams: consensusParams,
+		Validators: []types.GenesisValidator{{
+			Address: pubKey.Address(),
+			PubKey:  pubKey,
+			Power:   10,
+		}},
+	}
+	if err := genDoc.ValidateAndComplete(); err != nil {
+		return fmt.Errorf("validating genesis: %w", err)
+	}
+	return genDoc.SaveAs(config.GenesisFile())
+}
+
+// writeConfigFile is cfg.WriteConfigFile, returning an error instead of panicking.
+func writeConfigFile(path string, config *cfg.Config) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("writing %s: %v", path, r)
+		}
+	}()
+	cfg.WriteConfigFile(path, config)
+	return nil
+}
+
+// writeJSONFile writes v as CometBFT JSON, readable only by the owner since
+// it may hold a private key. FilePV.Save would panic on failure instead.
+func writeJSONFile(path string, v any) error {
+	bz, err := cmtjson.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, bz, 0o600)
+}
+
+func configFile(config *cfg.Config) string {
+	return filepath.Join(config.RootDir, cfg.DefaultConfigDir, cfg.DefaultConfigFileName)
+}
+
+func appConfigFile(config *cfg.Config) string {
+	return filepath.Join(config.RootDir, cfg.DefaultConfigDir, AppConfigFile)
+}
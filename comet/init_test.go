@@ -0,0 +1,71 @@
+package comet
+
+import (
+	"bytes"
+	"cometbft-baseapp/app"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cfg "github.com/cometbft/cometbft/config"
+	"github.com/cometbft/cometbft/privval"
+	"github.com/cometbft/cometbft/types"
+)
+
+func TestInitHome(t *testing.T) {
+	dir := t.TempDir()
+	if err := InitHome(dir, "test-chain", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{
+		"config/config.toml",
+		"config/app.toml",
+		"config/genesis.json",
+		"config/node_key.json",
+		"config/priv_validator_key.json",
+		"data/priv_validator_state.json",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("missing %s: %v", file, err)
+		}
+	}
+
+	config := cfg.DefaultConfig()
+	config.SetRoot(dir)
+	genDoc, err := types.GenesisDocFromFile(config.GenesisFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pv := privval.LoadFilePV(config.PrivValidatorKeyFile(), config.PrivValidatorStateFile())
+	if genDoc.ChainID != "test-chain" || len(genDoc.Validators) != 1 || !bytes.Equal(genDoc.Validators[0].PubKey.Bytes(), pv.Key.PubKey.Bytes()) {
+		t.Errorf("genesis = %+v, want test-chain with the generated validator", genDoc)
+	}
+	if params := genDoc.ConsensusParams; params.Block.MaxGas != app.DefaultMaxBlockGas || params.Feature.VoteExtensionsEnableHeight != 1 {
+		t.Errorf("genesis consensus params %+v, want max gas %d and vote extensions from height 1", params, app.DefaultMaxBlockGas)
+	}
+	if _, err := LoadAppConfig(filepath.Join(dir, "config", AppConfigFile)); err != nil {
+		t.Errorf("loading app.toml: %v", err)
+	}
+
+	if err := InitHome(dir, "test-chain", false); err == nil {
+		t.Fatal("second InitHome without force succeeded, want error")
+	}
+	if err := InitHome(dir, "test-chain", true); err != nil {
+		t.Fatalf("InitHome with force: %v", err)
+	}
+	if pv2 := privval.LoadFilePV(config.PrivValidatorKeyFile(), config.PrivValidatorStateFile()); bytes.Equal(pv2.Key.PubKey.Bytes(), pv.Key.PubKey.Bytes()) {
+		t.Error("InitHome with force kept the old validator key")
+	}
+}
+
+func TestInitHomeUnwritable(t *testing.T) {
+	// A home below a regular file can't be created, even by root.
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitHome(filepath.Join(file, "home"), "test-chain", false); err == nil {
+		t.Fatal("InitHome succeeded, want error")
+	}
+}
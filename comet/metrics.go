@@ -0,0 +1,77 @@
+package comet
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsSubsystem is the Prometheus subsystem for the application's metrics,
+// alongside CometBFT's own (consensus, mempool, p2p, ...).
+const MetricsSubsystem = "app"
+
+// Metrics contains the application's Prometheus metrics.
+type Metrics struct {
+	// Number of new txs seen by CheckTx; rechecks are not counted.
+	TxsChecked prometheus.Counter
+	// Number of txs successfully applied in FinalizeBlock.
+	TxsApplied prometheus.Counter
+	// Number of txs rejected by CheckTx (rechecks excluded) or FinalizeBlock.
+	TxsRejected prometheus.Counter
+	// Number of keys in the committed state.
+	StateKeys prometheus.Gauge
+	// Time spent in FinalizeBlock, in seconds.
+	FinalizeBlockDuration prometheus.Histogram
+}
+
+// NewMetrics creates the application's metrics. They are usable straight
+// away, but only exported once registered with Register.
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		TxsChecked: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "checked_txs",
+			Help:      "Number of new txs seen by CheckTx, not counting rechecks.",
+		}),
+		TxsApplied: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "applied_txs",
+			Help:      "Number of txs successfully applied in FinalizeBlock.",
+		}),
+		TxsRejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "rejected_txs",
+			Help:      "Number of txs rejected by CheckTx, not counting rechecks, or FinalizeBlock.",
+		}),
+		StateKeys: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "state_keys",
+			Help:      "Number of keys in the committed state.",
+		}),
+		FinalizeBlockDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "finalize_block_duration_seconds",
+			Help:      "Time spent in FinalizeBlock, in seconds.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+	}
+}
+
+// Register registers every metric with reg.
+func (m *Metrics) Register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{
+		m.TxsChecked,
+		m.TxsApplied,
+		m.TxsRejected,
+		m.StateKeys,
+		m.FinalizeBlockDuration,
+	} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
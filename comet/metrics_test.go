@@ -0,0 +1,57 @@
+package comet
+
+import (
+	"cometbft-baseapp/app"
+	"context"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics("test")
+	if err := metrics.Register(prometheus.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultAppConfig()
+	config.SnapshotDir = t.TempDir()
+	cometApp, err := NewCometApp(dbm.NewMemDB(), config, metrics, app.KVTxDecoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, req := range []*abci.CheckTxRequest{
+		{Tx: []byte("name=satoshi"), Type: abci.CHECK_TX_TYPE_CHECK},
+		{Tx: []byte("invalid"), Type: abci.CHECK_TX_TYPE_CHECK},
+		{Tx: []byte("name=satoshi"), Type: abci.CHECK_TX_TYPE_RECHECK},
+		{Tx: []byte("invalid"), Type: abci.CHECK_TX_TYPE_RECHECK},
+	} {
+		if _, err := cometApp.CheckTx(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.TxsChecked); got != 2 {
+		t.Errorf("checked txs = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(metrics.TxsRejected); got != 1 {
+		t.Errorf("rejected txs after CheckTx = %v, want 1", got)
+	}
+
+	commitBlock(t, cometApp, 1, "name=satoshi", "greeting=gm", "invalid")
+	if got := testutil.ToFloat64(metrics.TxsApplied); got != 2 {
+		t.Errorf("applied txs = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(metrics.TxsRejected); got != 2 {
+		t.Errorf("rejected txs after FinalizeBlock = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(metrics.StateKeys); got != 2 {
+		t.Errorf("state keys = %v, want 2", got)
+	}
+	if got := testutil.CollectAndCount(metrics.FinalizeBlockDuration); got != 1 {
+		t.Errorf("%d FinalizeBlock duration series, want 1", got)
+	}
+}